@@ -0,0 +1,145 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+)
+
+const (
+	// arraySummary reports the count, min, max, sum and avg of the elements of an array
+	arraySummary = "summary"
+	// arrayIndex reports each element of an array in its own metric set
+	arrayIndex = "index"
+)
+
+// arrayIndexAttribute is the attribute holding the index of the element
+// in the metric sets of an array reported with arrayIndex
+const arrayIndexAttribute = "index"
+
+// parseArrayOption reads the array option of an attribute definition
+func parseArrayOption(a map[string]interface{}) (string, error) {
+	raw, ok := a["array"]
+	if !ok {
+		return "", nil
+	}
+	option, _ := raw.(string)
+	if option != arraySummary && option != arrayIndex {
+		return "", fmt.Errorf("invalid array option %v, expected one of %s, %s", raw, arraySummary, arrayIndex)
+	}
+	if _, ok := a["table"]; ok && option == arrayIndex {
+		return "", fmt.Errorf("array %s can't be used with table", arrayIndex)
+	}
+	// The stats of a summary are always gauges, so a metric_type would be ignored
+	if _, ok := a["metric_type"]; ok && option == arraySummary {
+		return "", fmt.Errorf("array %s can't be used with metric_type, its stats are reported as gauges", arraySummary)
+	}
+	return option, nil
+}
+
+// parseArray reads the elements of an array value. nrjmx reports
+// arrays either as a list or as a string like [1, 2, 3].
+func parseArray(val interface{}) ([]float64, error) {
+	var elements []interface{}
+	switch v := val.(type) {
+	case []interface{}:
+		elements = v
+	case []float64:
+		return v, nil
+	case []int64:
+		for _, element := range v {
+			elements = append(elements, element)
+		}
+	case string:
+		s := strings.TrimSpace(v)
+		if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("%q is not an array", v)
+		}
+		s = strings.TrimSpace(s[1 : len(s)-1])
+		if s == "" {
+			return []float64{}, nil
+		}
+		for _, element := range strings.Split(s, ",") {
+			elements = append(elements, element)
+		}
+	default:
+		return nil, fmt.Errorf("%v is not an array", val)
+	}
+
+	values := make([]float64, 0, len(elements))
+	for _, element := range elements {
+		value, err := toNumber(element)
+		if err != nil {
+			return nil, fmt.Errorf("invalid array element: %w", err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// insertArraySummary reports the count, min, max, sum and avg of the elements of an array
+// as metricName.count, metricName.min and so on. min, max and avg are left out for empty arrays.
+// The stats describe the array of a single run, so they are gauges whatever the metric_type.
+func insertArraySummary(metricName string, val interface{}, attribute *attributeRequest, metricSet *metric.Set) error {
+	values, err := parseArray(val)
+	if err != nil {
+		return fmt.Errorf("failed to summarize %s: %w", metricName, err)
+	}
+
+	if err := metricSet.SetMetric(metricName+".count", float64(len(values)), metric.GAUGE); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	sum, min, max := 0.0, math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if t := attribute.transform; t != nil {
+			if v, err = t.apply(v); err != nil {
+				return err
+			}
+		}
+		sum += v
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+
+	stats := []struct {
+		name  string
+		value float64
+	}{
+		{"min", min},
+		{"max", max},
+		{"sum", sum},
+		{"avg", sum / float64(len(values))},
+	}
+	for _, stat := range stats {
+		if err := metricSet.SetMetric(metricName+"."+stat.name, stat.value, metric.GAUGE); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// arrayRows returns the elements of an array reported with arrayIndex, along
+// with the row of the metric set each of them is reported in
+func arrayRows(val interface{}) ([]float64, []*tableRow, error) {
+	values, err := parseArray(val)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows := make([]*tableRow, len(values))
+	for i := range values {
+		rows[i] = &tableRow{index: arrayIndexAttribute, value: strconv.Itoa(i)}
+	}
+	return values, rows, nil
+}
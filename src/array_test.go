@@ -0,0 +1,101 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseArray(t *testing.T) {
+	testCases := []struct {
+		val      interface{}
+		expected []float64
+	}{
+		{"[1, 2, 3]", []float64{1, 2, 3}},
+		{"[]", []float64{}},
+		{" [1.5] ", []float64{1.5}},
+		{[]interface{}{int64(4), 5.5, "6"}, []float64{4, 5.5, 6}},
+		{[]int64{7, 8}, []float64{7, 8}},
+	}
+
+	for _, tc := range testCases {
+		values, err := parseArray(tc.val)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, values)
+	}
+
+	_, err := parseArray("1, 2")
+	assert.EqualError(t, err, `"1, 2" is not an array`)
+	_, err = parseArray("[1, a]")
+	assert.EqualError(t, err, "invalid array element:  a is not a number")
+	_, err = parseArray(3)
+	assert.EqualError(t, err, "3 is not an array")
+}
+
+func TestInsertArraySummary(t *testing.T) {
+	i, _ := integration.New("jmx", "0.1.0")
+	e, _ := i.Entity("testEntity", "test")
+	metricSet := e.NewMetricSet("testSet")
+	attribute := &attributeRequest{metricName: "threadIds", metricType: -1, array: arraySummary}
+
+	assert.NoError(t, insertMetric("type=Threading,attr=AllThreadIds", "[4, 1, 7]", attribute, metricSet))
+	assert.Equal(t, 3.0, metricSet.Metrics["threadIds.count"])
+	assert.Equal(t, 1.0, metricSet.Metrics["threadIds.min"])
+	assert.Equal(t, 7.0, metricSet.Metrics["threadIds.max"])
+	assert.Equal(t, 12.0, metricSet.Metrics["threadIds.sum"])
+	assert.Equal(t, 4.0, metricSet.Metrics["threadIds.avg"])
+
+	metricSet = e.NewMetricSet("testSet")
+	assert.NoError(t, insertMetric("type=Threading,attr=AllThreadIds", "[]", attribute, metricSet))
+	assert.Equal(t, 0.0, metricSet.Metrics["threadIds.count"])
+	assert.NotContains(t, metricSet.Metrics, "threadIds.avg")
+}
+
+func TestInsertArraySummary_Rate(t *testing.T) {
+	i, _ := integration.New("jmx", "0.1.0", integration.InMemoryStore())
+	e, _ := i.Entity("testEntity", "test")
+	attribute := &attributeRequest{metricName: "requests", metricType: metric.RATE, array: arraySummary}
+
+	metricSet := e.NewMetricSet("testSet")
+	assert.NoError(t, insertMetric("type=Workers,attr=RequestCounts", "[10, 20, 30]", attribute, metricSet))
+	metricSet = e.NewMetricSet("testSet")
+	assert.NoError(t, insertMetric("type=Workers,attr=RequestCounts", "[15, 20, 70]", attribute, metricSet))
+
+	// The stats are the values of the run, not rates of them
+	assert.Equal(t, map[string]interface{}{
+		"event_type":     "testSet",
+		"requests.count": 3.0,
+		"requests.min":   15.0,
+		"requests.max":   70.0,
+		"requests.sum":   105.0,
+		"requests.avg":   35.0,
+	}, metricSet.Metrics)
+}
+
+func TestParseArrayOption(t *testing.T) {
+	option, err := parseArrayOption(map[string]interface{}{"array": "index"})
+	assert.NoError(t, err)
+	assert.Equal(t, arrayIndex, option)
+
+	_, err = parseArrayOption(map[string]interface{}{"array": "histogram"})
+	assert.EqualError(t, err, "invalid array option histogram, expected one of summary, index")
+
+	_, err = parseArrayOption(map[string]interface{}{"array": "index", "table": map[string]interface{}{"index": "pool"}})
+	assert.EqualError(t, err, "array index can't be used with table")
+
+	_, err = parseArrayOption(map[string]interface{}{"array": "summary", "metric_type": "rate"})
+	assert.EqualError(t, err, "array summary can't be used with metric_type, its stats are reported as gauges")
+
+	// The error is reported at the attribute
+	c, err := parseCollectionBytes([]byte("collect:\n  - domain: a\n    event_type: A\n    beans:\n      - query: '*'\n        attributes:\n          - {attr: Counts, array: summary, metric_type: rate}\n"), "file")
+	assert.NoError(t, err)
+	_, err = parseCollectionDefinition(c)
+	assert.EqualError(t, err, "file:7:13: array summary can't be used with metric_type, its stats are reported as gauges")
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/newrelic/infra-integrations-sdk/data/attribute"
@@ -34,11 +35,19 @@ type beanAttrValue struct {
 	value       interface{}
 }
 
-func runCollection(collection []*domainDefinition, i *integration.Integration, client Client, host, port string) error {
+// runCollection queries the beans of each domain of the collection and reports their
+// metrics. Domains and beans whose when condition is false for the JVM facts are skipped.
+func runCollection(collection []*domainDefinition, i *integration.Integration, client Client, facts *jvmFacts, host, port string) error {
 	for _, domain := range collection {
+		if !checkCondition(facts, domain.when, domain.domain) {
+			continue
+		}
 		var handlingErrs []error
 
 		for _, request := range domain.beans {
+			if !checkCondition(facts, request.when, fmt.Sprintf("%s:%s", domain.domain, request.beanQuery)) {
+				continue
+			}
 			response, err := client.QueryMBeanAttributes(fmt.Sprintf("%s:%s", domain.domain, request.beanQuery))
 			if jmxErr, ok := gojmx.IsJMXError(err); ok {
 				handlingErrs = append(handlingErrs, fmt.Errorf("%w, Pattern: %s:%s, error: %v", ErrNoDataForPattern, domain.domain, request.beanQuery, jmxErr))
@@ -68,16 +77,28 @@ func runCollection(collection []*domainDefinition, i *integration.Integration, c
 	return nil
 }
 
+// checkCondition tells whether the domain or bean with the when condition is collected,
+// logging why it's skipped
+func checkCondition(facts *jvmFacts, when *expression, name string) bool {
+	ok, err := facts.check(when)
+	if err != nil {
+		log.Error("Skipping %s, failed to evaluate its condition %s: %v", name, when.raw, err)
+		return false
+	}
+	if !ok {
+		log.Debug("Skipping %s, its condition %s is false", name, when.raw)
+	}
+	return ok
+}
+
 // matchRequest will return tha part of the config that requested the attribute.
 func matchRequest(jmxAttr *gojmx.AttributeResponse, request *beanRequest) *attributeRequest {
 	if jmxAttr == nil || request == nil {
 		return nil
 	}
 
-	for _, pattern := range request.exclude {
-		if pattern.MatchString(jmxAttr.Name) {
-			return nil
-		}
+	if isExcluded(jmxAttr, request) {
+		return nil
 	}
 
 	// For each attribute we want to collect, check if it matches
@@ -91,6 +112,109 @@ func matchRequest(jmxAttr *gojmx.AttributeResponse, request *beanRequest) *attri
 	return nil
 }
 
+// isExcluded tells whether the attribute matches any of the exclusion patterns
+// of the request, or doesn't match its include filters
+func isExcluded(jmxAttr *gojmx.AttributeResponse, request *beanRequest) bool {
+	if len(request.exclude) > 0 {
+		name := jmxAttr.Name
+		if request.excludeObjectName {
+			if i := strings.LastIndex(name, ",attr="); i != -1 {
+				name = name[:i]
+			}
+		}
+		if matchesAny(request.exclude, name) {
+			return true
+		}
+	}
+
+	if len(request.excludeDomains) > 0 || len(request.excludeBeans) > 0 || len(request.excludeAttributes) > 0 {
+		domain, beanAttr, err := splitBeanName(jmxAttr.Name)
+		if err != nil {
+			return true
+		}
+		beanName, err := getBeanName(beanAttr)
+		if err != nil {
+			return true
+		}
+		attrName, err := getAttrName(beanAttr)
+		if err != nil {
+			return true
+		}
+		if matchesAny(request.excludeDomains, domain) ||
+			matchesAny(request.excludeBeans, beanName) ||
+			matchesAny(request.excludeAttributes, attrName) {
+			return true
+		}
+	}
+
+	return !isIncluded(jmxAttr, request)
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIncluded tells whether the ObjectName of the attribute matches the query_regex
+// of the request, and its key properties match the include filters
+func isIncluded(jmxAttr *gojmx.AttributeResponse, request *beanRequest) bool {
+	if request.queryRegexp == nil && len(request.include) == 0 {
+		return true
+	}
+
+	domain, beanAttr, err := splitBeanName(jmxAttr.Name)
+	if err != nil {
+		return false
+	}
+	beanName, err := getBeanName(beanAttr)
+	if err != nil {
+		return false
+	}
+
+	if request.queryRegexp != nil && !request.queryRegexp.MatchString(domain+":"+beanName) {
+		return false
+	}
+
+	if len(request.include) == 0 {
+		return true
+	}
+	keyProperties, err := getKeyProperties(beanName)
+	if err != nil {
+		return false
+	}
+	for key, pattern := range request.include {
+		value, ok := keyProperties[key]
+		if !ok || !pattern.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDerivedInput tells whether the attribute is needed to compute a derived metric of the request
+func isDerivedInput(jmxAttr *gojmx.AttributeResponse, request *beanRequest) bool {
+	if jmxAttr == nil || request == nil || len(request.derived) == 0 || isExcluded(jmxAttr, request) {
+		return false
+	}
+
+	attrName, err := getAttrName(jmxAttr.Name)
+	if err != nil {
+		return false
+	}
+	for _, derived := range request.derived {
+		for _, ref := range derived.expression.refs() {
+			if strings.EqualFold(ref, attrName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // handleResponse takes a response, filters out the excluded beans,
 // sorts the responses by domain, and passes each domain off to
 // insertDomainMetrics to populate the metric list
@@ -101,7 +225,8 @@ func handleResponse(domain *domainDefinition, request *beanRequest, response []*
 	for _, attribute := range response {
 		attrRequest := matchRequest(attribute, request)
 		// Attribute was not requested by config or was filtered.
-		if attrRequest == nil {
+		// It's still kept, with a nil attrRequest, when a derived metric uses it.
+		if attrRequest == nil && !isDerivedInput(attribute, request) {
 			continue
 		}
 
@@ -138,30 +263,26 @@ func handleResponse(domain *domainDefinition, request *beanRequest, response []*
 // creates an entity and metric set for the domain, and populates the
 // metric set for each attribute to be collected
 func insertDomainMetrics(eventType string, domain string, beanAttrVals []*beanAttrValue, request *beanRequest, i *integration.Integration, host, port string) error {
-	// Create an entity for the domain
-	var e *integration.Entity
+	// Entities are created for each bean, as their name can use its key properties.
+	// Beans whose entity names are the same share the entity.
 	var err error
-	switch {
-	case args.RemoteMonitoring:
-		url := net.JoinHostPort(host, port)
-		if args.ConnectionURL != "" {
-			url = getConnectionURLSAP(args.ConnectionURL)
+	entities := make(map[string]*integration.Entity)
+	entityFor := func(beanName string) (*integration.Entity, error) {
+		if e, ok := entities[beanName]; ok {
+			return e, nil
 		}
-		e, err = newRemoteEntity(domain, url, i)
+		e, err := newDomainEntity(i, request, domain, beanName, host, port)
 		if err != nil {
-			return err
-		}
-	case args.LocalEntity:
-		e = i.LocalEntity()
-	default:
-		// create task for consistency with remote_monitoring
-		if args.ConnectionURL != "" {
-			host, port = getConnectionURLHostPort(args.ConnectionURL)
+			return nil, err
 		}
+		entities[beanName] = e
+		return e, nil
+	}
 
-		hostIDAttr := integration.NewIDAttribute("host", host)
-		portIDAttr := integration.NewIDAttribute("port", port)
-		e, err = i.Entity(domain, "jmx-domain", hostIDAttr, portIDAttr)
+	// The event type can be a template resolved for each bean
+	var eventTypeTemplate *nameTemplate
+	if isNameTemplate(eventType) {
+		eventTypeTemplate, err = parseBeanTemplate(eventType, "event_type")
 		if err != nil {
 			return err
 		}
@@ -169,6 +290,25 @@ func insertDomainMetrics(eventType string, domain string, beanAttrVals []*beanAt
 
 	// Create a map of bean names to metric sets
 	entityMetricSets := make(map[string]*metric.Set)
+	metricSetFor := func(beanName string, row *tableRow) (*metric.Set, error) {
+		beanEventType := eventType
+		if eventTypeTemplate != nil {
+			lookup, err := eventTypeLookup(domain, beanName)
+			if err != nil {
+				return nil, err
+			}
+			if beanEventType, err = eventTypeTemplate.resolve(lookup); err != nil {
+				return nil, fmt.Errorf("failed to resolve the event type of %s:%s: %w", domain, beanName, err)
+			}
+		}
+		e, err := entityFor(beanName)
+		if err != nil {
+			return nil, err
+		}
+		return getOrCreateMetricSet(entityMetricSets, e, request, beanName, row, beanEventType, domain)
+	}
+	// and of bean names to the values of their attributes, to compute derived metrics
+	beanValues := make(map[string]map[string]interface{})
 
 	// For each bean/attribute returned from this domain
 	for _, beanAttrVal := range beanAttrVals {
@@ -177,40 +317,144 @@ func insertDomainMetrics(eventType string, domain string, beanAttrVals []*beanAt
 			return err
 		}
 
-		// Query the metric set from the map or create it
-		metricSet, err := getOrCreateMetricSet(entityMetricSets, e, request, beanName, eventType, domain)
+		if len(request.derived) > 0 {
+			attrName, err := getAttrName(beanAttrVal.beanAttr)
+			if err != nil {
+				return err
+			}
+			if beanValues[beanName] == nil {
+				beanValues[beanName] = make(map[string]interface{})
+			}
+			beanValues[beanName][attrName] = beanAttrVal.value
+		}
+
+		// Attributes only needed by derived metrics are not reported
+		attrRequest := beanAttrVal.attrRequest
+		if attrRequest == nil {
+			continue
+		}
+
+		// Each element of an array reported by index gets its own metric set
+		if attrRequest.array == arrayIndex {
+			values, rows, err := arrayRows(beanAttrVal.value)
+			if err != nil {
+				return fmt.Errorf("failed to expand %s: %w", beanAttrVal.beanAttr, err)
+			}
+			for i, row := range rows {
+				metricSet, err := metricSetFor(beanName, row)
+				if err != nil {
+					return err
+				}
+				if err := insertMetric(beanAttrVal.beanAttr, values[i], attrRequest, metricSet); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Query the metric set from the map or create it. The rows of
+		// a table each get their own metric set.
+		var row *tableRow
+		if attrRequest.table != nil {
+			value, _ := attrRequest.compositePath(beanAttrVal.beanAttr)
+			row = &tableRow{index: attrRequest.table.index, value: value}
+		}
+		metricSet, err := metricSetFor(beanName, row)
 		if err != nil {
 			return err
 		}
 
 		// If we want to collect the metric, populate the metric list
-		if err := insertMetric(beanAttrVal.beanAttr, beanAttrVal.value, beanAttrVal.attrRequest, metricSet); err != nil {
+		if err := insertMetric(beanAttrVal.beanAttr, beanAttrVal.value, attrRequest, metricSet); err != nil {
 			return err
 		}
 	}
+
+	for beanName, values := range beanValues {
+		metricSet, err := metricSetFor(beanName, nil)
+		if err != nil {
+			return err
+		}
+		insertDerivedMetrics(request.derived, values, metricSet)
+	}
 	return nil
 }
 
+// insertDerivedMetrics computes the derived metrics of a bean from the values
+// of its attributes. Metrics that can't be computed, because an attribute is
+// missing or the expression divides by zero, are skipped.
+func insertDerivedMetrics(derived []*derivedMetric, values map[string]interface{}, metricSet *metric.Set) {
+	lookup := func(ref string) (interface{}, bool) {
+		if v, ok := values[ref]; ok {
+			return v, true
+		}
+		for name, v := range values {
+			if strings.EqualFold(name, ref) {
+				return v, true
+			}
+		}
+		return nil, false
+	}
+
+	for _, d := range derived {
+		value, err := d.expression.evalNumber(lookup)
+		if err != nil {
+			log.Debug("Skipping derived metric %s: %v", d.metricName, err)
+			continue
+		}
+
+		var metricValue interface{} = value
+		if d.metricType == metric.ATTRIBUTE {
+			metricValue = fmt.Sprintf("%v", value)
+		}
+		if err := metricSet.SetMetric(d.metricName, metricValue, d.metricType); err != nil {
+			log.Warn("Failed to set derived metric %s: %v", d.metricName, err)
+		}
+	}
+}
+
 // getOrCreateMetricSet takes a map of bean names to metric sets and either
 // returns a metric set from the map if it exists, or creates the metric set
-// and adds it to the map
-func getOrCreateMetricSet(entityMetricSets map[string]*metric.Set, e *integration.Entity, request *beanRequest, beanNameMatch string, eventType string, domain string) (*metric.Set, error) {
+// and adds it to the map. Table rows, when row is set, get their own metric set.
+func getOrCreateMetricSet(entityMetricSets map[string]*metric.Set, e *integration.Entity, request *beanRequest, beanNameMatch string, row *tableRow, eventType string, domain string) (*metric.Set, error) {
+	setKey := beanNameMatch
+	if row != nil {
+		setKey += "," + row.index + "=" + row.value
+	}
+
 	// If the metric set exists, return it
-	if ms, ok := entityMetricSets[beanNameMatch]; ok {
+	if ms, ok := entityMetricSets[setKey]; ok {
 		return ms, nil
 	}
 
+	// Custom attributes go first so that they can't override the ones below
+	var attributes []attribute.Attribute
+	tags := mergeTags(customAttributes, request.tags)
+	tagKeys := make([]string, 0, len(tags))
+	for key := range tags {
+		tagKeys = append(tagKeys, key)
+	}
+	sort.Strings(tagKeys)
+	for _, key := range tagKeys {
+		attributes = append(attributes, attribute.Attribute{Key: key, Value: tags[key]})
+	}
+
 	// Attributes in all metric sets
-	attributes := []attribute.Attribute{
+	attributes = append(attributes, []attribute.Attribute{
 		{Key: "query", Value: request.beanQuery},
 		{Key: "domain", Value: domain},
 		{Key: "host", Value: args.JmxHost},
 		{Key: "bean", Value: beanNameMatch},
-	}
+	}...)
 
 	if !args.LocalEntity {
+		// Templated entity names are used as written, the others are domain names
+		entityName := "domain:" + e.Metadata.Name
+		if request.entityName != nil || entityNameTemplate != nil {
+			entityName = e.Metadata.Name
+		}
 		nonLocalKeys := []attribute.Attribute{
-			{Key: "entityName", Value: "domain:" + e.Metadata.Name},
+			{Key: "entityName", Value: entityName},
 			{Key: "displayName", Value: e.Metadata.Name},
 		}
 		attributes = append(attributes, nonLocalKeys...)
@@ -222,21 +466,35 @@ func getOrCreateMetricSet(entityMetricSets map[string]*metric.Set, e *integratio
 		return nil, err
 	}
 	for key, val := range keyProperties {
-		attributes = append(attributes, attribute.Attribute{Key: "key:" + key, Value: val})
+		if name, ok := request.keyProperties.attributeName(key); ok {
+			attributes = append(attributes, attribute.Attribute{Key: name, Value: val})
+		}
+	}
+
+	if row != nil {
+		attributes = append(attributes, attribute.Attribute{Key: row.index, Value: row.value})
 	}
 
 	// Create the metric set and put it in the map
 	metricSet := e.NewMetricSet(eventType, attributes...)
-	entityMetricSets[beanNameMatch] = metricSet
+	entityMetricSets[setKey] = metricSet
 
 	return metricSet, nil
 }
 
 // Inserts a metric into a metric set, generating metric names
-// and metric types if unset
+// and metric types if unset, resolving metric name templates and
+// converting the value
 func insertMetric(key string, val interface{}, attribute *attributeRequest, metricSet *metric.Set) error {
 	// Generate a metric name if unset
 	metricName, err := func() (string, error) {
+		if attribute.metricNameTemplate != nil {
+			lookup, err := beanLookup(key)
+			if err != nil {
+				return "", err
+			}
+			return attribute.metricNameTemplate.resolve(lookup)
+		}
 		if attribute.metricName == "" {
 			metricName, err := getAttrName(key)
 			if err != nil {
@@ -251,10 +509,38 @@ func insertMetric(key string, val interface{}, attribute *attributeRequest, metr
 		return err
 	}
 
+	// Parts of a composite attribute are named after their composite key
+	if _, compositeKey := attribute.compositePath(key); compositeKey != "" {
+		if attribute.metricName == "" {
+			metricName = attribute.attrName
+		}
+		metricName += "." + compositeKey
+	}
+
+	// Record the unit the value is reported in
+	if t := attribute.transform; t != nil && t.unit != "" {
+		if err := metricSet.SetMetric("unit:"+metricName, t.unit, metric.ATTRIBUTE); err != nil {
+			return err
+		}
+	}
+
+	if attribute.array == arraySummary {
+		return insertArraySummary(metricName, val, attribute, metricSet)
+	}
+
+	// Convert the value
+	if t := attribute.transform; t != nil && t.converts() {
+		converted, err := t.apply(val)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s: %w", metricName, err)
+		}
+		val = converted
+	}
+
 	// Generate a metric type if unset
 	var metricType metric.SourceType
 	if attribute.metricType == -1 {
-		metricType = inferMetricType(val)
+		metricType = inferAttributeType(key, val, attribute.typeInference)
 	} else {
 		metricType = attribute.metricType
 	}
@@ -356,7 +642,7 @@ func generateEventType(domain string) (string, error) {
 		log.Error(
 			"Cannot generate an event type for the wildcarded domain %s."+
 				"For wildcarded domains, define a custom event type with event_type"+
-				"in the collection configuration file.", domain,
+				"in the collection configuration file, like {domain|title}Sample.", domain,
 		)
 		return "", fmt.Errorf("cannot generate event type for wildcarded domain %s", domain)
 	}
@@ -381,8 +667,53 @@ func inferMetricType(s interface{}) metric.SourceType {
 	}
 }
 
-func newRemoteEntity(domain, suffix string, i *integration.Integration) (*integration.Entity, error) {
-	return i.Entity(fmt.Sprintf("%s:%s", domain, suffix), "jmx-domain")
+// newDomainEntity creates the entity the metrics of a bean are reported on. Its name comes
+// from the entity_name of the collect block or ENTITY_NAME_TEMPLATE, and defaults to the
+// domain, followed by the connection in remote monitoring mode. Its type comes from the
+// entity_type of the collect block or ENTITY_TYPE.
+func newDomainEntity(i *integration.Integration, request *beanRequest, domain, beanName, host, port string) (*integration.Entity, error) {
+	if args.LocalEntity && !args.RemoteMonitoring {
+		return i.LocalEntity(), nil
+	}
+
+	entityType := request.entityType
+	if entityType == "" {
+		entityType = args.EntityType
+	}
+	if entityType == "" {
+		entityType = "jmx-domain"
+	}
+
+	url := net.JoinHostPort(host, port)
+	if args.ConnectionURL != "" {
+		url = getConnectionURLSAP(args.ConnectionURL)
+		host, port = getConnectionURLHostPort(args.ConnectionURL)
+	}
+
+	name := domain
+	if template := request.entityName; template != nil || entityNameTemplate != nil {
+		if template == nil {
+			template = entityNameTemplate
+		}
+		lookup, err := entityNameLookup(domain, beanName, host, port)
+		if err != nil {
+			return nil, err
+		}
+		if name, err = template.resolve(lookup); err != nil {
+			return nil, fmt.Errorf("failed to resolve the entity name of %s:%s: %w", domain, beanName, err)
+		}
+	} else if args.RemoteMonitoring {
+		name = fmt.Sprintf("%s:%s", domain, url)
+	}
+
+	if args.RemoteMonitoring {
+		return i.Entity(name, entityType)
+	}
+
+	// create task for consistency with remote_monitoring
+	hostIDAttr := integration.NewIDAttribute("host", host)
+	portIDAttr := integration.NewIDAttribute("port", port)
+	return i.Entity(name, entityType, hostIDAttr, portIDAttr)
 }
 
 // getConnectionURLSAP extracts last part that describes connection string,
@@ -21,7 +21,9 @@ import (
 
 type jmxClientMock struct {
 	response []*gojmx.AttributeResponse
-	err      error
+	// names are the bean names returned for each pattern
+	names map[string][]string
+	err   error
 }
 
 func (j *jmxClientMock) Open(config *gojmx.JMXConfig) (*gojmx.Client, error) {
@@ -31,6 +33,10 @@ func (j *jmxClientMock) Close() error {
 	return j.err
 }
 
+func (j *jmxClientMock) QueryMBeanNames(mBeanGlobPattern string) ([]string, error) {
+	return j.names[mBeanGlobPattern], j.err
+}
+
 func (j *jmxClientMock) QueryMBeanAttributes(mBeanNamePattern string, mBeanAttributeName ...string) ([]*gojmx.AttributeResponse, error) {
 	return j.response, j.err
 }
@@ -79,7 +85,7 @@ func TestRunCollection(t *testing.T) {
 
 	i, _ := integration.New("jmxtest", "0.1.0")
 
-	err := runCollection(collection, i, client, "testhost", "1234")
+	err := runCollection(collection, i, client, newJVMFacts(client), "testhost", "1234")
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 		t.FailNow()
@@ -239,6 +245,43 @@ func TestInsertMetric(t *testing.T) {
 	}
 }
 
+func TestInsertMetric_NameTemplate(t *testing.T) {
+	i, _ := integration.New("jmx", "0.1.0")
+	e, _ := i.Entity("testEntity", "test")
+	m := e.NewMetricSet("testSet")
+
+	attr, err := parseAttributeFromMap(map[string]interface{}{"attr_regex": "Collection.*", "metric_name": "gc.{name}.{attr}"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, insertMetric("type=GarbageCollector,name=G1,attr=CollectionCount", 10, attr, m))
+	assert.NoError(t, insertMetric("type=GarbageCollector,name=G1,attr=CollectionTime", 20, attr, m))
+	assert.Error(t, insertMetric("type=GarbageCollector,attr=CollectionTime", 20, attr, m))
+
+	assert.Equal(t, map[string]interface{}{
+		"event_type":            "testSet",
+		"gc.G1.CollectionCount": 10.0,
+		"gc.G1.CollectionTime":  20.0,
+	}, m.Metrics)
+}
+
+func TestInsertMetric_Transform(t *testing.T) {
+	i, _ := integration.New("jmx", "0.1.0")
+	e, _ := i.Entity("testEntity", "test")
+	m := e.NewMetricSet("testSet")
+
+	attr, err := parseAttributeFromMap(map[string]interface{}{"attr": "CollectionTime", "unit": "ms", "to_unit": "s"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, insertMetric("type=GarbageCollector,name=G1,attr=CollectionTime", int64(1500), attr, m))
+	assert.Error(t, insertMetric("type=GarbageCollector,name=G1,attr=CollectionTime", "unknown", attr, m))
+
+	assert.Equal(t, map[string]interface{}{
+		"event_type":          "testSet",
+		"CollectionTime":      1.5,
+		"unit:CollectionTime": "s",
+	}, m.Metrics)
+}
+
 func TestInsertDomainMetrics(t *testing.T) {
 	i, _ := integration.New("jmx", "0.1.0")
 	args = argumentList{}
@@ -299,6 +342,186 @@ func TestInsertDomainMetrics(t *testing.T) {
 	}
 }
 
+func TestInsertDomainMetrics_CustomAttributes(t *testing.T) {
+	i, _ := integration.New("jmx", "0.1.0")
+	args = argumentList{}
+	args.JmxHost = "localhost"
+	customAttributes = map[string]string{"team": "payments", "tier": "backend", "domain": "ignored"}
+	defer func() { customAttributes = nil }()
+
+	request := &beanRequest{
+		beanQuery: "type=Status",
+		attributes: []*attributeRequest{
+			{
+				attrRegexp: regexp.MustCompile("attr=Uptime$"),
+				metricName: "uptime",
+				metricType: metric.GAUGE,
+			},
+		},
+		tags: map[string]string{"tier": "frontend"},
+	}
+
+	beanAttrVals := []*beanAttrValue{
+		{
+			beanAttr:    "type=Status,attr=Uptime",
+			attrRequest: request.attributes[0],
+			value:       1.0,
+		},
+	}
+
+	err := insertDomainMetrics("TestEventTypeSample", "java.lang", beanAttrVals, request, i, "testhost", "1234")
+	assert.NoError(t, err)
+
+	metrics := i.Entities[0].Metrics[0].Metrics
+	assert.Equal(t, "payments", metrics["team"])
+	assert.Equal(t, "frontend", metrics["tier"])
+	assert.Equal(t, "java.lang", metrics["domain"])
+}
+
+func TestInsertDomainMetrics_EventTypeTemplate(t *testing.T) {
+	i, _ := integration.New("jmx", "0.1.0")
+	args = argumentList{}
+	args.JmxHost = "localhost"
+
+	request := &beanRequest{
+		beanQuery: "*",
+		attributes: []*attributeRequest{
+			{
+				attrRegexp: regexp.MustCompile("attr=Count$"),
+				metricName: "count",
+				metricType: metric.GAUGE,
+			},
+		},
+	}
+
+	beanAttrVals := []*beanAttrValue{
+		{beanAttr: "type=BrokerTopicMetrics,name=BytesInPerSec,attr=Count", attrRequest: request.attributes[0], value: 1.0},
+		{beanAttr: "type=BrokerTopicMetrics,name=BytesOutPerSec,attr=Count", attrRequest: request.attributes[0], value: 2.0},
+		{beanAttr: "type=request-channel,name=RequestQueueSize,attr=Count", attrRequest: request.attributes[0], value: 3.0},
+	}
+
+	err := insertDomainMetrics("{domain|title}{key:type|title}Sample", "kafka.server", beanAttrVals, request, i, "testhost", "1234")
+	assert.NoError(t, err)
+
+	eventTypes := make(map[string]int)
+	for _, ms := range i.Entities[0].Metrics {
+		eventTypes[ms.Metrics["event_type"].(string)]++
+	}
+	assert.Equal(t, map[string]int{
+		"KafkaServerBrokerTopicMetricsSample": 2,
+		"KafkaServerRequestChannelSample":     1,
+	}, eventTypes)
+
+	// Beans without the key property can't be reported
+	beanAttrVals = []*beanAttrValue{
+		{beanAttr: "name=Version,attr=Count", attrRequest: request.attributes[0], value: 1.0},
+	}
+	err = insertDomainMetrics("Kafka{key:type}Sample", "kafka.server", beanAttrVals, request, i, "testhost", "1234")
+	assert.Error(t, err)
+}
+
+func TestInsertDomainMetrics_EntityName(t *testing.T) {
+	i, _ := integration.New("jmx", "0.1.0")
+	args = argumentList{}
+	args.JmxHost = "localhost"
+	args.RemoteMonitoring = true
+	args.EntityType = "kafka-broker"
+	entityNameTemplate, _ = parseBeanTemplate("{host}:{port}", "entity_name_template")
+	defer func() { entityNameTemplate = nil }()
+
+	request := &beanRequest{
+		beanQuery: "*",
+		attributes: []*attributeRequest{
+			{
+				attrRegexp: regexp.MustCompile("attr=Count$"),
+				metricName: "count",
+				metricType: metric.GAUGE,
+			},
+		},
+	}
+	beanAttrVals := []*beanAttrValue{
+		{beanAttr: "type=BrokerTopicMetrics,attr=Count", attrRequest: request.attributes[0], value: 1.0},
+	}
+
+	// Wildcard matched domains are grouped under the same entity
+	assert.NoError(t, insertDomainMetrics("KafkaSample", "kafka.server", beanAttrVals, request, i, "broker-1", "9999"))
+	assert.NoError(t, insertDomainMetrics("KafkaSample", "kafka.network", beanAttrVals, request, i, "broker-1", "9999"))
+	assert.Len(t, i.Entities, 1)
+	assert.Equal(t, "broker-1:9999", i.Entities[0].Metadata.Name)
+	assert.Equal(t, "kafka-broker", i.Entities[0].Metadata.Namespace)
+	assert.Len(t, i.Entities[0].Metrics, 2)
+	assert.Equal(t, "broker-1:9999", i.Entities[0].Metrics[0].Metrics["entityName"])
+
+	// The collect block overrides the arguments, and can use the key properties
+	request.entityName, _ = parseBeanTemplate("checkout-{type}", "entity_name")
+	request.entityType = "checkout"
+	assert.NoError(t, insertDomainMetrics("KafkaSample", "kafka.server", beanAttrVals, request, i, "broker-1", "9999"))
+	assert.Len(t, i.Entities, 2)
+	assert.Equal(t, "checkout-BrokerTopicMetrics", i.Entities[1].Metadata.Name)
+	assert.Equal(t, "checkout", i.Entities[1].Metadata.Namespace)
+	assert.Equal(t, "checkout-BrokerTopicMetrics", i.Entities[1].Metrics[0].Metrics["entityName"])
+}
+
+func TestInsertDomainMetrics_DefaultEntityName(t *testing.T) {
+	i, _ := integration.New("jmx", "0.1.0")
+	args = argumentList{}
+	args.JmxHost = "localhost"
+	args.RemoteMonitoring = true
+
+	request := &beanRequest{
+		beanQuery:  "*",
+		attributes: []*attributeRequest{{attrRegexp: regexp.MustCompile("attr=Count$"), metricName: "count", metricType: metric.GAUGE}},
+	}
+	beanAttrVals := []*beanAttrValue{
+		{beanAttr: "type=BrokerTopicMetrics,attr=Count", attrRequest: request.attributes[0], value: 1.0},
+	}
+
+	assert.NoError(t, insertDomainMetrics("KafkaSample", "kafka.server", beanAttrVals, request, i, "broker-1", "9999"))
+	assert.Equal(t, "kafka.server:broker-1:9999", i.Entities[0].Metadata.Name)
+	assert.Equal(t, "jmx-domain", i.Entities[0].Metadata.Namespace)
+}
+
+func TestInsertDomainMetrics_KeyProperties(t *testing.T) {
+	i, _ := integration.New("jmx", "0.1.0")
+	args = argumentList{}
+	args.JmxHost = "localhost"
+
+	keyProperties, err := parseKeyProperties(&keyPropertiesParser{
+		Rename: map[string]string{"name": "poolName"},
+		Drop:   []string{"context"},
+	}, position{})
+	assert.NoError(t, err)
+
+	request := &beanRequest{
+		beanQuery: "type=DataSource,*",
+		attributes: []*attributeRequest{
+			{
+				attrRegexp: regexp.MustCompile("attr=numActive$"),
+				metricName: "numActive",
+				metricType: metric.GAUGE,
+			},
+		},
+		keyProperties: keyProperties,
+	}
+
+	beanAttrVals := []*beanAttrValue{
+		{
+			beanAttr:    "type=DataSource,name=jdbc/main,context=/app,attr=numActive",
+			attrRequest: request.attributes[0],
+			value:       3.0,
+		},
+	}
+
+	err = insertDomainMetrics("TestEventTypeSample", "Catalina", beanAttrVals, request, i, "testhost", "1234")
+	assert.NoError(t, err)
+
+	metrics := i.Entities[0].Metrics[0].Metrics
+	assert.Equal(t, "jdbc/main", metrics["poolName"])
+	assert.Equal(t, "DataSource", metrics["key:type"])
+	assert.NotContains(t, metrics, "key:name")
+	assert.NotContains(t, metrics, "key:context")
+}
+
 func TestHandleResponse(t *testing.T) {
 	domainDef := &domainDefinition{
 		eventType: "TestSample",
@@ -340,6 +563,123 @@ func TestHandleResponse(t *testing.T) {
 	assert.Equal(t, expectedMarshalled, string(jsonbytes))
 }
 
+func TestHandleResponse_Derived(t *testing.T) {
+	args = argumentList{}
+	c, err := parseJSON(`{"collect": [{"domain": "java.lang", "event_type": "JVMSample", "beans": [{
+		"query": "type=Memory",
+		"attributes": ["HeapMemoryUsage.Used"],
+		"derived": [
+			{"metric_name": "heap.utilization", "expression": "HeapMemoryUsage.Used / HeapMemoryUsage.Max * 100"},
+			{"metric_name": "heap.free", "expression": "HeapMemoryUsage.Max - HeapMemoryUsage.Used", "metric_type": "attribute"},
+			{"metric_name": "heap.missing", "expression": "HeapMemoryUsage.Committed / 2"}
+		]
+	}]}]}`)
+	assert.NoError(t, err)
+	domains, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+
+	response := []*gojmx.AttributeResponse{
+		{Name: "java.lang:type=Memory,attr=HeapMemoryUsage.Used", ResponseType: gojmx.ResponseTypeInt, IntValue: 256},
+		{Name: "java.lang:type=Memory,attr=HeapMemoryUsage.Max", ResponseType: gojmx.ResponseTypeInt, IntValue: 1024},
+		{Name: "java.lang:type=Memory,attr=NonHeapMemoryUsage.Max", ResponseType: gojmx.ResponseTypeInt, IntValue: 1024},
+	}
+
+	i, _ := integration.New("jmx", "0.1.0")
+	errs := handleResponse(domains[0], domains[0].beans[0], response, i, "testhost", "1234")
+	assert.Empty(t, errs)
+
+	metrics := i.Entities[0].Metrics[0].Metrics
+	assert.Equal(t, 256.0, metrics["HeapMemoryUsage.Used"])
+	assert.Equal(t, 25.0, metrics["heap.utilization"])
+	assert.Equal(t, "768", metrics["heap.free"])
+	assert.NotContains(t, metrics, "HeapMemoryUsage.Max")
+	assert.NotContains(t, metrics, "heap.missing")
+}
+
+func TestHandleResponse_Table(t *testing.T) {
+	args = argumentList{}
+	args.JmxHost = "localhost"
+
+	domainDef := &domainDefinition{
+		eventType: "JVMSample",
+	}
+	attribute, err := parseAttributeFromMap(map[string]interface{}{
+		"attr":        "LastGcInfo.memoryUsageAfterGc",
+		"metric_name": "afterGc",
+		"keys":        []interface{}{"used"},
+		"table":       map[string]interface{}{"index": "pool"},
+	})
+	assert.NoError(t, err)
+	request := &beanRequest{
+		beanQuery:  "type=GarbageCollector,*",
+		attributes: []*attributeRequest{attribute},
+	}
+
+	response := []*gojmx.AttributeResponse{
+		{
+			Name:         "java.lang:type=GarbageCollector,attr=LastGcInfo.memoryUsageAfterGc.Eden.Used",
+			ResponseType: gojmx.ResponseTypeInt,
+			IntValue:     10,
+		},
+		{
+			Name:         "java.lang:type=GarbageCollector,attr=LastGcInfo.memoryUsageAfterGc.Eden.Max",
+			ResponseType: gojmx.ResponseTypeInt,
+			IntValue:     100,
+		},
+		{
+			Name:         "java.lang:type=GarbageCollector,attr=LastGcInfo.memoryUsageAfterGc.Survivor.Used",
+			ResponseType: gojmx.ResponseTypeInt,
+			IntValue:     20,
+		},
+	}
+
+	i, _ := integration.New("jmx", "0.1.0")
+	errs := handleResponse(domainDef, request, response, i, "testhost", "1234")
+	assert.Nil(t, errs)
+
+	rows := make(map[string]interface{})
+	for _, metricSet := range i.Entities[0].Metrics {
+		assert.NotContains(t, metricSet.Metrics, "afterGc.Max")
+		rows[metricSet.Metrics["pool"].(string)] = metricSet.Metrics["afterGc.used"]
+	}
+	assert.Equal(t, map[string]interface{}{"Eden": 10.0, "Survivor": 20.0}, rows)
+}
+
+func TestInsertDomainMetrics_ArrayIndex(t *testing.T) {
+	i, _ := integration.New("jmx", "0.1.0")
+	args = argumentList{}
+	args.JmxHost = "localhost"
+
+	request := &beanRequest{
+		beanQuery: "type=Partitions",
+		attributes: []*attributeRequest{
+			{
+				attrRegexp: regexp.MustCompile("attr=Lag$"),
+				metricName: "lag",
+				metricType: metric.GAUGE,
+				array:      arrayIndex,
+			},
+		},
+	}
+
+	beanAttrVals := []*beanAttrValue{
+		{
+			beanAttr:    "type=Partitions,attr=Lag",
+			attrRequest: request.attributes[0],
+			value:       "[3, 0, 12]",
+		},
+	}
+
+	err := insertDomainMetrics("KafkaSample", "kafka", beanAttrVals, request, i, "testhost", "1234")
+	assert.NoError(t, err)
+
+	lags := make(map[string]interface{})
+	for _, metricSet := range i.Entities[0].Metrics {
+		lags[metricSet.Metrics["index"].(string)] = metricSet.Metrics["lag"]
+	}
+	assert.Equal(t, map[string]interface{}{"0": 3.0, "1": 0.0, "2": 12.0}, lags)
+}
+
 func TestDefaultMetricType(t *testing.T) {
 	defs, err := parseYaml("../test/data/activemq.yml")
 	assert.NoError(t, err)
@@ -503,3 +843,92 @@ func Test_matchRequestNil(t *testing.T) {
 
 	assert.Nil(t, matchRequest(&gojmx.AttributeResponse{}, &beanRequest{}))
 }
+
+func Test_matchRequestInclude(t *testing.T) {
+	jmxAttributes := []*gojmx.AttributeResponse{
+		{Name: `Catalina:type=ThreadPool,name="http-nio-8080",attr=currentThreadsBusy`},
+		{Name: `Catalina:type=ThreadPool,name="ajp-nio-8009",attr=currentThreadsBusy`},
+		{Name: `Catalina:type=ThreadPool,name="http-nio-8443",attr=currentThreadsBusy`},
+		{Name: `Catalina:type=ThreadPool,attr=currentThreadsBusy`},
+	}
+
+	testCases := []struct {
+		bean     *beanDefinitionParser
+		expected []string
+	}{
+		{
+			&beanDefinitionParser{Query: "type=ThreadPool,*", Include: map[string]string{"name": "^http-nio-"}},
+			[]string{jmxAttributes[0].Name, jmxAttributes[2].Name},
+		},
+		{
+			&beanDefinitionParser{Query: "type=ThreadPool,*", QueryRegex: `^Catalina:type=ThreadPool,name=".*-8080"$`},
+			[]string{jmxAttributes[0].Name},
+		},
+		{
+			&beanDefinitionParser{Query: "type=ThreadPool,*", QueryRegex: "nio", Include: map[string]string{"name": "8009"}},
+			[]string{jmxAttributes[1].Name},
+		},
+	}
+
+	for _, tc := range testCases {
+		request, err := parseBean(tc.bean)
+		assert.NoError(t, err)
+
+		var actual []string
+		for _, jmxAttr := range jmxAttributes {
+			if matchRequest(jmxAttr, request) != nil {
+				actual = append(actual, jmxAttr.Name)
+			}
+		}
+		assert.Equal(t, tc.expected, actual)
+	}
+}
+
+func Test_matchRequestExcludeTargets(t *testing.T) {
+	jmxAttributes := []*gojmx.AttributeResponse{
+		{Name: `Catalina:type=Cache,name=requestCount,attr=hitCount`},
+		{Name: `Catalina:type=Cache,name=other,attr=requestCount`},
+		{Name: `Catalina:type=Manager,name=other,attr=hitCount`},
+		{Name: `Tomcat:type=Cache,name=other,attr=hitCount`},
+	}
+
+	testCases := []struct {
+		bean     *beanDefinitionParser
+		expected []string
+	}{
+		{
+			&beanDefinitionParser{Query: "*", ExcludeAttributes: "requestCount"},
+			[]string{jmxAttributes[0].Name, jmxAttributes[2].Name, jmxAttributes[3].Name},
+		},
+		{
+			&beanDefinitionParser{Query: "*", ExcludeBeans: []interface{}{"type=Manager", "name=requestCount"}},
+			[]string{jmxAttributes[1].Name, jmxAttributes[3].Name},
+		},
+		{
+			&beanDefinitionParser{Query: "*", ExcludeDomains: "^Tomcat$"},
+			[]string{jmxAttributes[0].Name, jmxAttributes[1].Name, jmxAttributes[2].Name},
+		},
+		{
+			&beanDefinitionParser{Query: "*", Exclude: "requestCount"},
+			[]string{jmxAttributes[2].Name, jmxAttributes[3].Name},
+		},
+		// From version 2, exclude_regex only matches the ObjectName
+		{
+			&beanDefinitionParser{Query: "*", Exclude: "requestCount", version: 2},
+			[]string{jmxAttributes[1].Name, jmxAttributes[2].Name, jmxAttributes[3].Name},
+		},
+	}
+
+	for _, tc := range testCases {
+		request, err := parseBean(tc.bean)
+		assert.NoError(t, err)
+
+		var actual []string
+		for _, jmxAttr := range jmxAttributes {
+			if matchRequest(jmxAttr, request) != nil {
+				actual = append(actual, jmxAttr.Name)
+			}
+		}
+		assert.Equal(t, tc.expected, actual)
+	}
+}
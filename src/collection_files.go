@@ -0,0 +1,79 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+// expandCollectionFiles turns the comma separated list of COLLECTION_FILES into
+// the collection files to collect. Entries can be files, directories, whose .yml
+// and .yaml files are all used, or glob patterns. Relative entries are resolved
+// against the directory of configFile, or the working directory when it is empty.
+// The files of a directory or a pattern are sorted, and files listed more than
+// once are only returned the first time.
+func expandCollectionFiles(collectionFiles string, configFile string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, entry := range strings.Split(collectionFiles, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !filepath.IsAbs(entry) && configFile != "" {
+			entry = filepath.Join(filepath.Dir(configFile), entry)
+		}
+
+		if strings.ContainsAny(entry, "*?[") {
+			matches, err := filepath.Glob(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid metrics collection pattern %s: %w", entry, err)
+			}
+			if len(matches) == 0 {
+				log.Warn("No metrics collection files match %s", entry)
+			}
+			sort.Strings(matches)
+			for _, match := range matches {
+				add(match)
+			}
+			continue
+		}
+
+		info, err := os.Stat(entry)
+		if err != nil || !info.IsDir() {
+			// Missing files are reported when they are parsed
+			add(entry)
+			continue
+		}
+
+		dirEntries, err := os.ReadDir(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metrics collection directory %s: %w", entry, err)
+		}
+		// ReadDir returns the entries sorted by file name
+		for _, dirEntry := range dirEntries {
+			ext := filepath.Ext(dirEntry.Name())
+			if !dirEntry.IsDir() && (ext == ".yml" || ext == ".yaml") {
+				add(filepath.Join(entry, dirEntry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
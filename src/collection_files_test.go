@@ -0,0 +1,38 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandCollectionFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"jmx.d/tomcat.yml", "jmx.d/jvm.yaml", "jmx.d/README.md", "kafka/consumer.yml", "kafka/producer.yml", "extra.yml"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte("collect: []\n"), 0644))
+	}
+	configFile := filepath.Join(dir, "jmx-config.yml")
+
+	files, err := expandCollectionFiles("jmx.d, "+filepath.Join(dir, "kafka", "*.yml")+",extra.yml,jmx.d/jvm.yaml", configFile)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "jmx.d", "jvm.yaml"),
+		filepath.Join(dir, "jmx.d", "tomcat.yml"),
+		filepath.Join(dir, "kafka", "consumer.yml"),
+		filepath.Join(dir, "kafka", "producer.yml"),
+		filepath.Join(dir, "extra.yml"),
+	}, files)
+
+	files, err = expandCollectionFiles("../test/data/test-sample.yml", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"../test/data/test-sample.yml"}, files)
+}
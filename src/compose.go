@@ -0,0 +1,149 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// loadCollectionFile parses a collection file and resolves the collection
+// files it includes and extends
+func loadCollectionFile(filename string) (*collectionDefinitionParser, error) {
+	return resolveCollectionFile(filename, nil)
+}
+
+// resolveCollectionFile parses a collection file reached through chain, the files
+// including or extending it, and resolves the collection files it includes and extends
+func resolveCollectionFile(filename string, chain []string) (*collectionDefinitionParser, error) {
+	for i, previous := range chain {
+		if previous == filename {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(chain[i:], " -> "), filename)
+		}
+	}
+
+	c, err := parseYaml(filename)
+	if err != nil {
+		if len(chain) > 0 {
+			return nil, fmt.Errorf("failed to load %s, included from %s: %w", filename, strings.Join(chain, " -> "), err)
+		}
+		return nil, err
+	}
+	// The full slice expression keeps the chains of sibling files apart
+	return resolveComposition(c, filepath.Dir(filename), append(chain[:len(chain):len(chain)], filename))
+}
+
+// resolveComposition merges the blocks of the collection definition with the
+// ones of the collection files it includes and extends. The blocks of the
+// extended file come first, overridden by the ones of the definition, followed
+// by the blocks of the included files. Relative paths are resolved against dir.
+func resolveComposition(c *collectionDefinitionParser, dir string, chain []string) (*collectionDefinitionParser, error) {
+	if c.Extends == "" && len(c.Include) == 0 {
+		return c, nil
+	}
+
+	resolved := &collectionDefinitionParser{problems: c.problems}
+	load := func(path string) (*collectionDefinitionParser, error) {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		loaded, err := resolveCollectionFile(path, chain)
+		if err != nil {
+			return nil, err
+		}
+		resolved.problems = append(resolved.problems, loaded.problems...)
+		return loaded, nil
+	}
+
+	var base []collectBlock
+	if c.Extends != "" {
+		extended, err := load(c.Extends)
+		if err != nil {
+			return nil, err
+		}
+		base = extended.Collect
+	}
+	resolved.Collect = overrideBlocks(base, c.Collect)
+
+	for _, include := range c.Include {
+		included, err := load(include)
+		if err != nil {
+			return nil, err
+		}
+		resolved.Collect = append(resolved.Collect, included.Collect...)
+	}
+	return resolved, nil
+}
+
+// overrideBlocks returns the base blocks overridden by the given blocks. The beans of
+// a block for a domain already in base replace the base beans with the same query, or
+// remove them when they set remove. Blocks for other domains are added as they are.
+func overrideBlocks(base, overrides []collectBlock) []collectBlock {
+	blocks := make([]collectBlock, len(base))
+	copy(blocks, base)
+
+	for _, override := range overrides {
+		i := findBlock(blocks, override.Domain)
+		if i == -1 {
+			blocks = append(blocks, override)
+			continue
+		}
+
+		block := &blocks[i]
+		if override.EventType != "" {
+			block.EventType = override.EventType
+		}
+		if override.When != "" {
+			block.When = override.When
+		}
+		if override.EntityName != "" {
+			block.EntityName = override.EntityName
+		}
+		if override.EntityType != "" {
+			block.EntityType = override.EntityType
+		}
+		if override.TypeInference != "" {
+			block.TypeInference = override.TypeInference
+		}
+		block.Tags = mergeTags(block.Tags, override.Tags)
+
+		beans := make([]beanDefinitionParser, len(block.Beans))
+		copy(beans, block.Beans)
+		for _, bean := range override.Beans {
+			j := findBean(beans, bean.Query)
+			switch {
+			case j == -1:
+				// A bean to remove that isn't there is kept, so it's reported
+				beans = append(beans, bean)
+			case bean.Remove:
+				beans = append(beans[:j], beans[j+1:]...)
+			default:
+				beans[j] = bean
+			}
+		}
+		block.Beans = beans
+	}
+	return blocks
+}
+
+func findBlock(blocks []collectBlock, domain string) int {
+	for i, block := range blocks {
+		if block.Domain == domain {
+			return i
+		}
+	}
+	return -1
+}
+
+func findBean(beans []beanDefinitionParser, query string) int {
+	for i, bean := range beans {
+		if bean.Query == query {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCollectionFile_Extends(t *testing.T) {
+	c, err := loadCollectionFile("../test/data/test-sample-extends.yml")
+	assert.NoError(t, err)
+
+	domains, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+	assert.Len(t, domains, 2)
+
+	assert.Equal(t, "Catalina", domains[0].domain)
+	assert.Equal(t, "TomcatSample", domains[0].eventType)
+	var queries []string
+	for _, bean := range domains[0].beans {
+		queries = append(queries, bean.beanQuery)
+	}
+	assert.Equal(t, []string{"type=ThreadPool,name=*", "type=Manager,context=*,host=*", "type=GlobalRequestProcessor,name=*"}, queries)
+	assert.Equal(t, "attr=currentThreadsBusy$", domains[0].beans[0].attributes[0].attrRegexp.String())
+
+	assert.Equal(t, "java.lang", domains[1].domain)
+
+	// Beans keep the position in the file they come from
+	assert.Equal(t, "../test/data/test-sample-base.yml", c.Collect[0].Beans[1].pos.file)
+	assert.Equal(t, "../test/data/test-sample-extends.yml", c.Collect[0].Beans[0].pos.file)
+}
+
+func TestLoadCollectionFile_Cycle(t *testing.T) {
+	_, err := loadCollectionFile("../test/data/test-sample-cycle-a.yml")
+	assert.EqualError(t, err, "include cycle: ../test/data/test-sample-cycle-a.yml -> ../test/data/test-sample-cycle-b.yml -> ../test/data/test-sample-cycle-a.yml")
+}
+
+func TestOverrideBlocks_RemoveUnknownBean(t *testing.T) {
+	c := &collectionDefinitionParser{Collect: overrideBlocks(
+		[]collectBlock{{Domain: "java.lang", Beans: []beanDefinitionParser{{Query: "type=Memory", Attributes: []interface{}{"HeapMemoryUsage.Used"}}}}},
+		[]collectBlock{{Domain: "java.lang", Beans: []beanDefinitionParser{{Query: "type=Threading", Remove: true}}}},
+	)}
+
+	_, err := parseCollectionDefinition(c)
+	assert.EqualError(t, err, "no bean with query type=Threading to remove, remove only applies to the beans of an extended collection file")
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tableRequest expands the rows of a TabularData attribute into their own
+// metric sets. nrjmx flattens a row as attr.<row index>.<column>.
+type tableRequest struct {
+	// index is the name of the attribute holding the row index in the metric set of each row
+	index string
+}
+
+// tableRow identifies the row of a table a metric set is reported for
+type tableRow struct {
+	index string
+	value string
+}
+
+// parseCompositeOptions reads the keys and table options of an attribute definition.
+// Both need the attribute to be selected by its name with attr.
+func parseCompositeOptions(a map[string]interface{}) ([]string, *tableRequest, error) {
+	rawKeys, hasKeys := a["keys"]
+	rawTable, hasTable := a["table"]
+	if !hasKeys && !hasTable {
+		return nil, nil, nil
+	}
+	if _, ok := a["attr"]; !ok {
+		return nil, nil, fmt.Errorf("keys and table require the attribute to be selected with attr")
+	}
+
+	var keys []string
+	if hasKeys {
+		list, ok := rawKeys.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, nil, fmt.Errorf("keys must be a non empty list of composite keys")
+		}
+		for _, rawKey := range list {
+			key, ok := rawKey.(string)
+			if !ok || key == "" || strings.HasPrefix(key, ".") || strings.HasSuffix(key, ".") {
+				return nil, nil, fmt.Errorf("invalid composite key %v", rawKey)
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	var table *tableRequest
+	if hasTable {
+		options, ok := rawTable.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("table must be a map")
+		}
+		index, ok := options["index"].(string)
+		if !ok || index == "" {
+			return nil, nil, fmt.Errorf("table requires the name of the index attribute with index")
+		}
+		table = &tableRequest{index: index}
+	}
+	return keys, table, nil
+}
+
+// createCompositeRegex creates the pattern matching the composite keys of an attribute,
+// and the row index of its table. Composite keys are matched ignoring case, since
+// nrjmx capitalizes them.
+func createCompositeRegex(attrName string, keys []string, table *tableRequest) (*regexp.Regexp, error) {
+	pattern := "attr=" + regexp.QuoteMeta(attrName)
+	if table != nil {
+		pattern += `\.(?P<row>[^.]+)`
+	}
+	if keys != nil {
+		quoted := make([]string, 0, len(keys))
+		for _, key := range keys {
+			quoted = append(quoted, regexp.QuoteMeta(key))
+		}
+		pattern += `\.(?P<key>(?i:` + strings.Join(quoted, "|") + `))`
+	} else {
+		pattern += `\.(?P<key>.+)`
+	}
+	return regexp.Compile(pattern + "$")
+}
+
+// compositePath returns the table row and the composite key of a bean attribute
+// matched by the request. The key is spelled as in the keys list when it is set.
+func (a *attributeRequest) compositePath(beanAttr string) (row, key string) {
+	if a.keys == nil && a.table == nil {
+		return "", ""
+	}
+	matches := a.attrRegexp.FindStringSubmatch(beanAttr)
+	if matches == nil {
+		return "", ""
+	}
+	for i, name := range a.attrRegexp.SubexpNames() {
+		switch name {
+		case "row":
+			row = matches[i]
+		case "key":
+			key = matches[i]
+		}
+	}
+	for _, configured := range a.keys {
+		if strings.EqualFold(configured, key) {
+			return row, configured
+		}
+	}
+	return row, key
+}
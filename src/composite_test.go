@@ -0,0 +1,67 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAttributeFromMap_Composite(t *testing.T) {
+	attribute, err := parseAttributeFromMap(map[string]interface{}{
+		"attr": "HeapMemoryUsage",
+		"keys": []interface{}{"used", "max"},
+	})
+	assert.NoError(t, err)
+
+	row, key := attribute.compositePath("type=Memory,attr=HeapMemoryUsage.Used")
+	assert.Equal(t, "", row)
+	assert.Equal(t, "used", key)
+	assert.False(t, attribute.attrRegexp.MatchString("type=Memory,attr=HeapMemoryUsage.Committed"))
+	assert.False(t, attribute.attrRegexp.MatchString("type=Memory,attr=HeapMemoryUsage"))
+
+	attribute, err = parseAttributeFromMap(map[string]interface{}{
+		"attr":  "LastGcInfo.memoryUsageAfterGc",
+		"keys":  []interface{}{"value.used"},
+		"table": map[string]interface{}{"index": "pool"},
+	})
+	assert.NoError(t, err)
+
+	row, key = attribute.compositePath("type=GarbageCollector,name=G1 Young Generation,attr=LastGcInfo.memoryUsageAfterGc.G1 Eden Space.value.used")
+	assert.Equal(t, "G1 Eden Space", row)
+	assert.Equal(t, "value.used", key)
+	assert.False(t, attribute.attrRegexp.MatchString("attr=LastGcInfo.memoryUsageAfterGc.G1 Eden Space.value.max"))
+}
+
+func TestParseAttributeFromMap_CompositeErrors(t *testing.T) {
+	testCases := []struct {
+		attribute map[string]interface{}
+		expected  string
+	}{
+		{
+			map[string]interface{}{"attr_regex": "Heap.*", "keys": []interface{}{"used"}},
+			"keys and table require the attribute to be selected with attr",
+		},
+		{
+			map[string]interface{}{"attr": "HeapMemoryUsage", "keys": []interface{}{}},
+			"keys must be a non empty list of composite keys",
+		},
+		{
+			map[string]interface{}{"attr": "HeapMemoryUsage", "keys": []interface{}{"used."}},
+			"invalid composite key used.",
+		},
+		{
+			map[string]interface{}{"attr": "LastGcInfo.memoryUsageAfterGc", "table": map[string]interface{}{}},
+			"table requires the name of the index attribute with index",
+		},
+	}
+
+	for _, tc := range testCases {
+		_, err := parseAttributeFromMap(tc.attribute)
+		assert.EqualError(t, err, tc.expected)
+	}
+}
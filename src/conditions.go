@@ -0,0 +1,132 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/newrelic/nrjmx/gojmx"
+)
+
+// runtimeBean is the bean the JVM facts of when conditions are read from
+const runtimeBean = "java.lang:type=Runtime"
+
+// runtimeFacts are the attributes of runtimeBean when conditions can reference
+var runtimeFacts = []string{"SpecVersion", "VmName", "VmVendor", "VmVersion"}
+
+// parseCondition parses the when condition of a collect block or bean, like
+// major(SpecVersion) >= 11 && has_bean('java.lang:type=GarbageCollector,name=ZGC*')
+func parseCondition(raw string) (*expression, error) {
+	when, err := parseExpression(raw)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range when.refs() {
+		known := false
+		for _, fact := range runtimeFacts {
+			known = known || ref == fact
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid condition %q: %w %s, expected one of %s", raw, ErrUnknownReference, ref, strings.Join(runtimeFacts, ", "))
+		}
+	}
+	return when, nil
+}
+
+// contextCalls returns the functions called by the expression that depend on where it
+// is evaluated, like has_bean, which only when conditions provide
+func contextCalls(e *expression) []string {
+	var calls []string
+	for _, name := range e.calls() {
+		if exprFunctions[name].call == nil {
+			calls = append(calls, name)
+		}
+	}
+	return calls
+}
+
+// jvmFacts are the facts about the monitored JVM when conditions are evaluated
+// against. Each fact is queried the first time a condition needs it, so they
+// are gathered once per run, and only when used.
+type jvmFacts struct {
+	client Client
+
+	runtime    map[string]interface{}
+	runtimeErr error
+
+	// beans tells for each ObjectName pattern whether any bean matches it,
+	// domains are cached as the domain:* pattern
+	beans map[string]bool
+}
+
+func newJVMFacts(client Client) *jvmFacts {
+	return &jvmFacts{client: client, beans: make(map[string]bool)}
+}
+
+// check evaluates a when condition, nil conditions are always true
+func (f *jvmFacts) check(when *expression) (bool, error) {
+	if when == nil {
+		return true, nil
+	}
+	if len(when.refs()) > 0 {
+		if err := f.gatherRuntime(); err != nil {
+			return false, err
+		}
+	}
+
+	lookup := func(ref string) (interface{}, bool) {
+		v, ok := f.runtime[ref]
+		return v, ok
+	}
+	return when.evalBool(lookup, map[string]exprFunc{
+		"has_domain": f.hasDomain,
+		"has_bean":   f.hasBean,
+	})
+}
+
+// gatherRuntime reads the runtimeFacts attributes of runtimeBean
+func (f *jvmFacts) gatherRuntime() error {
+	if f.runtime != nil || f.runtimeErr != nil {
+		return f.runtimeErr
+	}
+
+	response, err := f.client.QueryMBeanAttributes(runtimeBean, runtimeFacts...)
+	if err != nil {
+		f.runtimeErr = fmt.Errorf("failed to query %s: %w", runtimeBean, err)
+		return f.runtimeErr
+	}
+	f.runtime = make(map[string]interface{}, len(runtimeFacts))
+	for _, attribute := range response {
+		if attribute.ResponseType == gojmx.ResponseTypeErr {
+			continue
+		}
+		if name, err := getAttrName(attribute.Name); err == nil {
+			f.runtime[name] = attribute.GetValue()
+		}
+	}
+	return nil
+}
+
+// hasDomain tells whether any domain matches the pattern, like kafka.*. Only the
+// beans of the matching domains are queried, not every bean of the server.
+func (f *jvmFacts) hasDomain(args []interface{}) (interface{}, error) {
+	return f.hasBean([]interface{}{fmt.Sprintf("%v:*", args[0])})
+}
+
+// hasBean tells whether any bean matches the ObjectName pattern, like java.lang:type=GarbageCollector,*
+func (f *jvmFacts) hasBean(args []interface{}) (interface{}, error) {
+	pattern := fmt.Sprintf("%v", args[0])
+	if found, ok := f.beans[pattern]; ok {
+		return found, nil
+	}
+	names, err := f.client.QueryMBeanNames(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", pattern, err)
+	}
+	f.beans[pattern] = len(names) > 0
+	return len(names) > 0, nil
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nrjmx/gojmx"
+	"github.com/stretchr/testify/assert"
+)
+
+// factsClientMock answers the queries of jvmFacts and counts the queries of each pattern
+type factsClientMock struct {
+	jmxClientMock
+	runtime []*gojmx.AttributeResponse
+	queries map[string]int
+}
+
+func (f *factsClientMock) QueryMBeanNames(mBeanGlobPattern string) ([]string, error) {
+	f.queries["names "+mBeanGlobPattern]++
+	return f.jmxClientMock.QueryMBeanNames(mBeanGlobPattern)
+}
+
+func (f *factsClientMock) QueryMBeanAttributes(mBeanNamePattern string, mBeanAttributeName ...string) ([]*gojmx.AttributeResponse, error) {
+	f.queries["attributes "+mBeanNamePattern]++
+	if mBeanNamePattern == runtimeBean {
+		return f.runtime, f.err
+	}
+	return f.jmxClientMock.QueryMBeanAttributes(mBeanNamePattern, mBeanAttributeName...)
+}
+
+func newFactsClientMock() *factsClientMock {
+	return &factsClientMock{
+		jmxClientMock: jmxClientMock{
+			names: map[string][]string{
+				"kafka.*:*": {"kafka.server:type=BrokerTopicMetrics"},
+				"java.lang:type=GarbageCollector,name=ZGC*": {"java.lang:type=GarbageCollector,name=ZGC Cycles"},
+			},
+		},
+		runtime: []*gojmx.AttributeResponse{
+			{Name: "java.lang:type=Runtime,attr=SpecVersion", ResponseType: gojmx.ResponseTypeString, StringValue: "17"},
+			{Name: "java.lang:type=Runtime,attr=VmVendor", ResponseType: gojmx.ResponseTypeString, StringValue: "Eclipse Adoptium"},
+			{Name: "java.lang:type=Runtime,attr=VmName", ResponseType: gojmx.ResponseTypeErr, StatusMsg: "unavailable"},
+		},
+		queries: make(map[string]int),
+	}
+}
+
+func TestJVMFacts_Check(t *testing.T) {
+	client := newFactsClientMock()
+	facts := newJVMFacts(client)
+
+	testCases := []struct {
+		when        string
+		expected    bool
+		expectedErr bool
+	}{
+		{"major(SpecVersion) >= 11", true, false},
+		{"VmVendor == 'Eclipse Adoptium' && has_domain('kafka.*')", true, false},
+		{"has_domain('tomcat')", false, false},
+		{"has_domain('tomcat') || has_domain('kafka.*')", true, false},
+		{"has_bean('java.lang:type=GarbageCollector,name=ZGC*')", true, false},
+		{"has_bean('java.lang:type=GarbageCollector,name=G1*')", false, false},
+		{"has_bean('java.lang:type=GarbageCollector,name=ZGC*') && major(SpecVersion) > 11", true, false},
+		{"VmName == 'OpenJDK'", false, true},
+	}
+	for _, tc := range testCases {
+		when, err := parseCondition(tc.when)
+		if !assert.NoError(t, err, tc.when) {
+			continue
+		}
+		ok, err := facts.check(when)
+		assert.Equal(t, tc.expectedErr, err != nil, tc.when)
+		assert.Equal(t, tc.expected, ok, tc.when)
+	}
+
+	// Each fact is gathered once
+	assert.Equal(t, map[string]int{
+		"attributes " + runtimeBean:                       1,
+		"names kafka.*:*":                                 1,
+		"names tomcat:*":                                  1,
+		"names java.lang:type=GarbageCollector,name=ZGC*": 1,
+		"names java.lang:type=GarbageCollector,name=G1*":  1,
+	}, client.queries)
+
+	ok, err := facts.check(nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestJVMFacts_QueryError(t *testing.T) {
+	client := newFactsClientMock()
+	client.err = errors.New("connection lost")
+	facts := newJVMFacts(client)
+
+	for _, raw := range []string{"SpecVersion == '17'", "has_domain('kafka.*')", "has_bean('java.lang:*')"} {
+		when, err := parseCondition(raw)
+		assert.NoError(t, err)
+		_, err = facts.check(when)
+		assert.ErrorContains(t, err, "connection lost", raw)
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	_, err := parseCondition("major(SpecVersion) >= 11 && has_bean('java.lang:type=Memory')")
+	assert.NoError(t, err)
+
+	_, err = parseCondition("HeapMemoryUsage > 0")
+	assert.ErrorIs(t, err, ErrUnknownReference)
+	assert.ErrorContains(t, err, "expected one of SpecVersion, VmName, VmVendor, VmVersion")
+
+	_, err = parseCondition("has_bean(")
+	assert.Error(t, err)
+}
+
+func TestRunCollection_When(t *testing.T) {
+	client := newFactsClientMock()
+	client.response = []*gojmx.AttributeResponse{
+		{Name: "java.lang:type=GarbageCollector,name=ZGC Cycles,attr=CollectionCount", ResponseType: gojmx.ResponseTypeInt, IntValue: 3},
+	}
+
+	c, err := parseJSON(`{"collect": [
+		{"domain": "java.lang", "event_type": "GCSample", "beans": [
+			{"query": "type=GarbageCollector,name=G1*", "when": "has_bean('java.lang:type=GarbageCollector,name=G1*')", "attributes": ["CollectionCount"]},
+			{"query": "type=GarbageCollector,name=ZGC*", "when": "has_bean('java.lang:type=GarbageCollector,name=ZGC*')", "attributes": ["CollectionCount"]}]},
+		{"domain": "Catalina", "event_type": "TomcatSample", "when": "has_domain('Catalina')", "beans": [{"query": "type=Manager,*"}]}]}`)
+	assert.NoError(t, err)
+	collection, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+
+	i, _ := integration.New("jmxtest", "0.1.0")
+	assert.NoError(t, runCollection(collection, i, client, newJVMFacts(client), "testhost", "1234"))
+
+	// Only the ZGC bean is queried
+	assert.Equal(t, 1, client.queries["attributes java.lang:type=GarbageCollector,name=ZGC*"])
+	assert.Equal(t, 0, client.queries["attributes java.lang:type=GarbageCollector,name=G1*"])
+	assert.Equal(t, 0, client.queries["attributes Catalina:type=Manager,*"])
+	if assert.Len(t, i.Entities, 1) {
+		assert.Equal(t, 3.0, i.Entities[0].Metrics[0].Metrics["CollectionCount"])
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// jsonSchemaDraft is the JSON Schema version of the exported schema
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// collectionJSONSchema exports the schema collection files are checked against
+// as a JSON Schema, for editors and configuration pipelines. Both are generated
+// from the same schemaNode table, so they accept the same files.
+func collectionJSONSchema() ([]byte, error) {
+	schema := collectionSchema.jsonSchema()
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = "nri-jmx collection definition"
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// jsonSchema converts the schema node to its JSON Schema equivalent
+func (s *schemaNode) jsonSchema() map[string]interface{} {
+	schema := make(map[string]interface{})
+	if s.description != "" {
+		schema["description"] = s.description
+	}
+
+	switch s.kind {
+	case schemaString:
+		schema["type"] = "string"
+		if len(s.enum) > 0 {
+			schema["enum"] = s.enum
+		}
+	case schemaNumber:
+		schema["type"] = "number"
+		if len(s.enum) > 0 {
+			values := make([]float64, 0, len(s.enum))
+			for _, value := range s.enum {
+				number, _ := strconv.ParseFloat(value, 64)
+				values = append(values, number)
+			}
+			schema["enum"] = values
+		}
+	case schemaBool:
+		schema["type"] = "boolean"
+	case schemaList:
+		schema["type"] = "array"
+		schema["items"] = s.items.jsonSchema()
+	case schemaMap:
+		schema["type"] = "object"
+		// A map without fields accepts any key
+		if s.fields == nil {
+			schema["additionalProperties"] = s.items.jsonSchema()
+			break
+		}
+		properties := make(map[string]interface{}, len(s.fields))
+		for key, field := range s.fields {
+			properties[key] = field.jsonSchema()
+		}
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+		if len(s.required) > 0 {
+			required := append([]string(nil), s.required...)
+			sort.Strings(required)
+			schema["required"] = required
+		}
+	case schemaOneOf:
+		alternatives := make([]interface{}, 0, len(s.oneOf))
+		for _, alternative := range s.oneOf {
+			alternatives = append(alternatives, alternative.jsonSchema())
+		}
+		schema["oneOf"] = alternatives
+	}
+	return schema
+}
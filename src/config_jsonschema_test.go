@@ -0,0 +1,75 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xeipuuv/gojsonschema"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func validateWithJSONSchema(t *testing.T, data []byte) *gojsonschema.Result {
+	schema, err := collectionJSONSchema()
+	assert.NoError(t, err)
+
+	var document interface{}
+	assert.NoError(t, yaml.Unmarshal(data, &document))
+
+	result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(schema), gojsonschema.NewGoLoader(document))
+	assert.NoError(t, err)
+	return result
+}
+
+func TestCollectionJSONSchema_UpToDate(t *testing.T) {
+	schema, err := collectionJSONSchema()
+	assert.NoError(t, err)
+
+	shipped, err := os.ReadFile(filepath.Join("..", "collection-schema.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(schema), string(shipped), "regenerate collection-schema.json with -print_collection_schema")
+}
+
+func TestCollectionJSONSchema_Valid(t *testing.T) {
+	files := []string{filepath.Join("..", "test", "data", "test-sample.yml")}
+	for _, name := range presetNames() {
+		files = append(files, filepath.Join("presets", name+".yml"))
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		assert.NoError(t, err)
+
+		// The parser and the JSON Schema accept the same files
+		c, err := parseCollectionBytes(data, file)
+		assert.NoError(t, err, file)
+		assert.Empty(t, c.problems, file)
+		result := validateWithJSONSchema(t, data)
+		assert.True(t, result.Valid(), "%s: %v", file, result.Errors())
+	}
+}
+
+func TestCollectionJSONSchema_Invalid(t *testing.T) {
+	file := filepath.Join("..", "test", "data", "test-sample-strict.yml")
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+
+	c, err := parseCollectionBytes(data, file)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, c.problems)
+	result := validateWithJSONSchema(t, data)
+	assert.False(t, result.Valid())
+
+	var fields []string
+	for _, e := range result.Errors() {
+		fields = append(fields, e.Field()+": "+e.Type())
+	}
+	assert.Contains(t, fields, "collect.0: additional_property_not_allowed")
+	assert.Contains(t, fields, "collect.1.beans.0.exclude_regex: number_one_of")
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// attributeRule is the raw form of an attribute definition,
+// used to lint the definitions of a bean against each other
+type attributeRule struct {
+	pos        position
+	name       string
+	literal    bool
+	metricName string
+	regexp     *regexp.Regexp
+}
+
+// validateCollections checks every collection file, the collection config and
+// the collection presets without connecting to JMX. All the problems found are
+// written to w, and their count is returned.
+func validateCollections(w io.Writer) int {
+	var sources []string
+	var problems collectionErrors
+
+	check := func(c *collectionDefinitionParser) {
+		if _, err := parseCollectionDefinition(c); err != nil {
+			problems = append(problems, err.(collectionErrors)...)
+		}
+		problems = append(problems, lintCollectionDefinition(c)...)
+	}
+
+	if args.CollectionFiles != "" {
+		collectionFiles, err := expandCollectionFiles(args.CollectionFiles, args.ConfigFile)
+		if err != nil {
+			problems.add(position{}, err)
+		}
+		for _, collectionFile := range collectionFiles {
+			sources = append(sources, collectionFile)
+			c, err := loadCollectionFile(collectionFile)
+			if err != nil {
+				problems.add(position{file: collectionFile}, err)
+				continue
+			}
+			check(c)
+		}
+	}
+
+	if args.CollectionConfig != "" {
+		sources = append(sources, "COLLECTION_CONFIG")
+		c, err := parseJSON(args.CollectionConfig)
+		if err == nil {
+			c, err = resolveComposition(c, "", []string{"COLLECTION_CONFIG"})
+		}
+		if err != nil {
+			problems.add(position{file: "COLLECTION_CONFIG"}, err)
+		} else {
+			check(c)
+		}
+	}
+
+	for _, name := range splitPresets(args.CollectionPresets) {
+		sources = append(sources, "preset:"+name)
+		c, err := loadPreset(name)
+		if err != nil {
+			problems.add(position{file: "preset:" + name}, err)
+			continue
+		}
+		check(c)
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(w, problem.Error())
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(w, "Found %d problems in %s\n", len(problems), strings.Join(sources, ", "))
+	} else {
+		fmt.Fprintf(w, "No problems found in %s\n", strings.Join(sources, ", "))
+	}
+	return len(problems)
+}
+
+// runValidation validates the collections with validateCollections, writing the
+// problems to w. It returns the exit code, 1 when any problem was found.
+func runValidation(w io.Writer) int {
+	if validateCollections(w) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// lintCollectionDefinition looks for definitions that are valid but
+// won't work as intended: metric names used twice in the same bean and
+// attribute rules that can never match an attribute.
+func lintCollectionDefinition(c *collectionDefinitionParser) collectionErrors {
+	var problems collectionErrors
+	for _, block := range c.Collect {
+		for i := range block.Beans {
+			problems = append(problems, lintBean(&block.Beans[i])...)
+		}
+	}
+	return problems
+}
+
+func lintBean(bean *beanDefinitionParser) collectionErrors {
+	var problems collectionErrors
+	report := func(pos position, format string, a ...interface{}) {
+		problems = append(problems, &collectionError{pos: pos, scope: pos, msg: fmt.Sprintf(format, a...)})
+	}
+
+	metricNames := make(map[string]position)
+	var previous []*attributeRule
+	for i, raw := range bean.Attributes {
+		rule := newAttributeRule(raw, bean.attributePosition(i))
+		if rule == nil {
+			continue
+		}
+
+		if rule.metricName != "" {
+			if firstPos, ok := metricNames[rule.metricName]; ok {
+				report(rule.pos, "metric name %q is already used in this bean at %s", rule.metricName, firstPos)
+			} else {
+				metricNames[rule.metricName] = rule.pos
+			}
+		}
+
+		if !rule.literal && strings.HasPrefix(rule.name, "^") {
+			report(rule.pos, "attr_regex %q can never match, patterns are already anchored to the start of the attribute name", rule.name)
+		}
+
+		for _, earlier := range previous {
+			if earlier.shadows(rule) {
+				report(rule.pos, "attribute rule can never match, attributes are already matched by the rule at %s", earlier.pos)
+				break
+			}
+		}
+		previous = append(previous, rule)
+	}
+
+	for _, derived := range bean.Derived {
+		if firstPos, ok := metricNames[derived.MetricName]; ok {
+			report(derived.pos, "metric name %q is already used in this bean at %s", derived.MetricName, firstPos)
+		} else {
+			metricNames[derived.MetricName] = derived.pos
+		}
+	}
+	return problems
+}
+
+// newAttributeRule reads a raw attribute definition, returning nil
+// for the definitions parseAttributes would refuse and for the ones
+// selecting composite keys or table rows, whose metric names depend on them
+func newAttributeRule(raw interface{}, pos position) *attributeRule {
+	rule := &attributeRule{pos: pos}
+	switch a := raw.(type) {
+	case string:
+		rule.name, rule.literal, rule.metricName = a, true, a
+	case map[string]interface{}:
+		if _, ok := a["keys"]; ok {
+			return nil
+		}
+		if _, ok := a["table"]; ok {
+			return nil
+		}
+		if name, ok := a["attr"].(string); ok {
+			rule.name, rule.literal, rule.metricName = name, true, name
+		} else if regex, ok := a["attr_regex"].(string); ok {
+			rule.name = regex
+		} else {
+			return nil
+		}
+		if metricName, ok := a["metric_name"].(string); ok {
+			rule.metricName = metricName
+		}
+	default:
+		return nil
+	}
+
+	r, err := createAttributeRegex(rule.name, rule.literal)
+	if err != nil {
+		return nil
+	}
+	rule.regexp = r
+	return rule
+}
+
+// shadows tells whether every attribute matching other is already matched
+// by r. Since matchRequest uses the first matching rule, other would never be used.
+func (r *attributeRule) shadows(other *attributeRule) bool {
+	if other.literal {
+		return r.regexp.MatchString("attr=" + other.name)
+	}
+	// A regex shadows any other regex only when it matches every attribute
+	return !r.literal && (r.name == ".*" || r.name == other.name)
+}
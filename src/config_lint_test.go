@@ -0,0 +1,66 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintCollectionDefinition(t *testing.T) {
+	file := "../test/data/test-sample-lint.yml"
+	c, err := parseYaml(file)
+	assert.NoError(t, err)
+
+	var messages []string
+	for _, problem := range lintCollectionDefinition(c) {
+		messages = append(messages, problem.Error())
+	}
+	assert.Equal(t, []string{
+		file + `:10:19: metric name "Used" is already used in this bean at ` + file + `:9:19`,
+		file + `:15:19: attribute rule can never match, attributes are already matched by the rule at ` + file + `:14:19`,
+		file + `:16:19: attr_regex "^Peak.*" can never match, patterns are already anchored to the start of the attribute name`,
+		file + `:18:19: attribute rule can never match, attributes are already matched by the rule at ` + file + `:17:19`,
+	}, messages)
+}
+
+func TestValidateCollections(t *testing.T) {
+	args = argumentList{}
+	args.CollectionFiles = "../test/data/test-sample.yml,../test/data/test-sample-lint.yml"
+	args.CollectionConfig = `{"collect": [{"domain": "test.*", "beans": [{"query": "type=Test"}]}]}`
+
+	var out bytes.Buffer
+	problems := validateCollections(&out)
+
+	assert.Equal(t, 6, problems)
+	assert.Contains(t, out.String(), "../test/data/test-sample-lint.yml:4:13: invalid regex pattern invalid(\n")
+	assert.Contains(t, out.String(), "COLLECTION_CONFIG:1:14: cannot generate event type for wildcarded domain test.*\n")
+	assert.Contains(t, out.String(), "Found 6 problems in ../test/data/test-sample.yml, ../test/data/test-sample-lint.yml, COLLECTION_CONFIG\n")
+}
+
+func TestRunValidation_WrongType(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "collection.yml")
+	assert.NoError(t, os.WriteFile(file, []byte("collect:\n  - domain: a\n    beans:\n      - query: '*'\n        attributes:\n          - attr: 42\n"), 0600))
+	args = argumentList{}
+	args.CollectionFiles = file
+
+	var out bytes.Buffer
+	assert.Equal(t, 1, runValidation(&out))
+	assert.Equal(t, file+":6:19: attr: expected a string, got int \"42\"\nFound 1 problems in "+file+"\n", out.String())
+}
+
+func TestValidateCollections_Valid(t *testing.T) {
+	args = argumentList{}
+	args.CollectionFiles = "../test/data/test-sample.yml"
+
+	var out bytes.Buffer
+	assert.Equal(t, 0, validateCollections(&out))
+	assert.Equal(t, "No problems found in ../test/data/test-sample.yml\n", out.String())
+}
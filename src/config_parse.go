@@ -6,10 +6,16 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/log"
@@ -17,23 +23,160 @@ import (
 )
 
 type collectBlock struct {
-	Domain    string                 `yaml:"domain" json:"domain"`
-	EventType string                 `yaml:"event_type" json:"event_type"`
-	Beans     []beanDefinitionParser `yaml:"beans" json:"beans"`
+	Domain    string `yaml:"domain" json:"domain"`
+	EventType string `yaml:"event_type" json:"event_type"`
+	// When is a condition on the JVM facts the block is collected on
+	When string `yaml:"when" json:"when"`
+	// EntityName and EntityType override ENTITY_NAME_TEMPLATE and ENTITY_TYPE
+	EntityName string `yaml:"entity_name" json:"entity_name"`
+	EntityType string `yaml:"entity_type" json:"entity_type"`
+	// TypeInference overrides TYPE_INFERENCE for the attributes without a metric_type
+	TypeInference string                 `yaml:"type_inference" json:"type_inference"`
+	Tags          map[string]string      `yaml:"tags" json:"tags"`
+	Beans         []beanDefinitionParser `yaml:"beans" json:"beans"`
+
+	pos position
 }
 
+// currentCollectionVersion is the newest version of the collection format. Files
+// without a version are version 1. Version 2 matches exclude_regex against the
+// ObjectName of the beans only, attributes are excluded with exclude_attributes.
+const currentCollectionVersion = 2
+
+// collectionVersions are the values accepted for version
+var collectionVersions = []string{"1", "2"}
+
 // collectionDefinitionParser is a struct to aid the automatic
 // parsing of a collection yaml file
 type collectionDefinitionParser struct {
+	// Version is the version of the collection format, 1 when it isn't set
+	Version int `yaml:"version" json:"version"`
+	// Include lists the collection files whose blocks are collected along with this file's
+	Include []string `yaml:"include" json:"include"`
+	// Extends is the collection file this file's blocks override
+	Extends string `yaml:"extends" json:"extends"`
 	Collect []collectBlock
+
+	// problems are the schema errors found while decoding the definition
+	problems collectionErrors
 }
 
 // beanDefinitionParser is a struct to aid the automatic
 // parsing of a collection yaml file
 type beanDefinitionParser struct {
-	Query      string        `yaml:"query" json:"query"`
-	Exclude    interface{}   `yaml:"exclude_regex" json:"exclude_regex"`
-	Attributes []interface{} `yaml:"attributes" json:"attributes"`
+	Query      string            `yaml:"query" json:"query"`
+	QueryRegex string            `yaml:"query_regex" json:"query_regex"`
+	Include    map[string]string `yaml:"include" json:"include"`
+	// When is a condition on the JVM facts the bean is collected on
+	When string `yaml:"when" json:"when"`
+	// ExcludeDomains, ExcludeBeans and ExcludeAttributes can be
+	// either a string or a list of strings, like Exclude
+	ExcludeDomains    interface{}               `yaml:"exclude_domains" json:"exclude_domains"`
+	ExcludeBeans      interface{}               `yaml:"exclude_beans" json:"exclude_beans"`
+	ExcludeAttributes interface{}               `yaml:"exclude_attributes" json:"exclude_attributes"`
+	Exclude           interface{}               `yaml:"exclude_regex" json:"exclude_regex"`
+	Attributes        []interface{}             `yaml:"attributes" json:"attributes"`
+	Derived           []derivedDefinitionParser `yaml:"derived" json:"derived"`
+	Tags              map[string]string         `yaml:"tags" json:"tags"`
+	KeyProperties     *keyPropertiesParser      `yaml:"key_properties" json:"key_properties"`
+	// Remove drops the bean with the same query from the extended collection file
+	Remove bool `yaml:"remove" json:"remove"`
+
+	pos     position
+	attrPos []position
+	// version is the version of the collection file the bean comes from
+	version int
+}
+
+// keyPropertiesParser is a struct to aid the automatic parsing
+// of the key_properties block of a bean
+type keyPropertiesParser struct {
+	Rename map[string]string `yaml:"rename" json:"rename"`
+	Drop   []string          `yaml:"drop" json:"drop"`
+	Keep   []string          `yaml:"keep" json:"keep"`
+}
+
+// derivedDefinitionParser is a struct to aid the automatic
+// parsing of a derived metric of a bean
+type derivedDefinitionParser struct {
+	MetricName string `yaml:"metric_name" json:"metric_name"`
+	Expression string `yaml:"expression" json:"expression"`
+	MetricType string `yaml:"metric_type" json:"metric_type"`
+
+	pos position
+}
+
+// UnmarshalYAML decodes a collect block keeping track of its position
+func (c *collectBlock) UnmarshalYAML(node *yaml.Node) error {
+	type plain collectBlock
+	if err := node.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	c.pos = nodePosition(node)
+	return nil
+}
+
+// UnmarshalYAML decodes a bean definition keeping track of its position
+// and the position of each of its attributes
+func (b *beanDefinitionParser) UnmarshalYAML(node *yaml.Node) error {
+	type plain beanDefinitionParser
+	if err := node.Decode((*plain)(b)); err != nil {
+		return err
+	}
+	b.pos = nodePosition(node)
+	for _, pair := range mappingPairs(node) {
+		if pair[0].Value == "attributes" {
+			for _, attr := range pair[1].Content {
+				b.attrPos = append(b.attrPos, nodePosition(attr))
+			}
+		}
+	}
+	return nil
+}
+
+// UnmarshalYAML decodes a derived metric keeping track of its position
+func (d *derivedDefinitionParser) UnmarshalYAML(node *yaml.Node) error {
+	type plain derivedDefinitionParser
+	if err := node.Decode((*plain)(d)); err != nil {
+		return err
+	}
+	d.pos = nodePosition(node)
+	return nil
+}
+
+// attributePosition returns the position of the i-th attribute of the bean,
+// or the position of the bean itself when it is unknown
+func (b *beanDefinitionParser) attributePosition(i int) position {
+	if i < len(b.attrPos) {
+		return b.attrPos[i]
+	}
+	return b.pos
+}
+
+// setSource records the file the definition was read from in the
+// position of all of its blocks, beans, attributes and problems
+func (c *collectionDefinitionParser) setSource(source string) {
+	for i := range c.Collect {
+		block := &c.Collect[i]
+		block.pos.file = source
+		for j := range block.Beans {
+			bean := &block.Beans[j]
+			bean.pos.file = source
+			// Beans keep the version of their file, as extending and
+			// including files mixes the beans of several files
+			bean.version = c.Version
+			for k := range bean.attrPos {
+				bean.attrPos[k].file = source
+			}
+			for k := range bean.Derived {
+				bean.Derived[k].pos.file = source
+			}
+		}
+	}
+	for _, problem := range c.problems {
+		problem.pos.file = source
+		problem.scope.file = source
+	}
 }
 
 // domainDefinition is a validated and simplified
@@ -42,7 +185,9 @@ type beanDefinitionParser struct {
 type domainDefinition struct {
 	domain    string
 	eventType string
-	beans     []*beanRequest
+	// when is the condition the domain is collected on, nil to always collect it
+	when  *expression
+	beans []*beanRequest
 }
 
 // attributeRequest is a storage struct containing
@@ -52,7 +197,21 @@ type attributeRequest struct {
 	// attrRegexp is a compiled regex pattern that matches the attribute
 	attrRegexp *regexp.Regexp
 	metricName string
-	metricType metric.SourceType
+	// metricNameTemplate is set when metricName has placeholders resolved for each bean
+	metricNameTemplate *nameTemplate
+	metricType         metric.SourceType
+	// transform converts the value before it is reported, nil when unset
+	transform *valueTransform
+	// keys are the composite keys reported, nil to report every key
+	keys []string
+	// table, when set, expands the rows of a TabularData attribute into their own metric sets
+	table *tableRequest
+	// attrName is the composite attribute name keys are appended to when metricName is unset
+	attrName string
+	// array is how array values are reported, arraySummary or arrayIndex, empty when unset
+	array string
+	// typeInference is the type_inference of the collect block, empty to use TYPE_INFERENCE
+	typeInference string
 }
 
 // beanRequest is a storage struct containing the
@@ -61,12 +220,55 @@ type attributeRequest struct {
 type beanRequest struct {
 	beanQuery string
 	// exclude is a list of compiled regex that matches beans to exclude from collection
-	exclude    []*regexp.Regexp
-	attributes []*attributeRequest
+	exclude []*regexp.Regexp
+	// excludeObjectName tells whether exclude matches the ObjectName of the bean,
+	// as in version 2 collection files, instead of the ObjectName and the attribute
+	excludeObjectName bool
+	// excludeDomains, excludeBeans and excludeAttributes are lists of compiled regex
+	// matching the domain, the bean key properties and the attribute name to exclude
+	excludeDomains    []*regexp.Regexp
+	excludeBeans      []*regexp.Regexp
+	excludeAttributes []*regexp.Regexp
+	// include maps key properties to the compiled regex their value must match to be collected
+	include map[string]*regexp.Regexp
+	// queryRegexp, when set, must match the ObjectName of the beans to collect
+	queryRegexp *regexp.Regexp
+	attributes  []*attributeRequest
+	// derived are the metrics computed from other attributes of the bean
+	derived []*derivedMetric
+	// keyProperties controls how the ObjectName key properties are reported,
+	// nil to report all of them as key:<name>
+	keyProperties *keyPropertiesRule
+	// tags are the custom attributes added to the samples of the bean,
+	// the ones of its collect block merged with its own
+	tags map[string]string
+	// entityName and entityType are the entity overrides of its collect block
+	entityName *nameTemplate
+	entityType string
+	// when is the condition the bean is collected on, nil to always collect it
+	when *expression
+}
+
+// keyPropertiesRule is a storage struct containing the information
+// necessary to turn the key properties of a bean into attributes
+type keyPropertiesRule struct {
+	// rename maps key properties to the attribute name they are reported as
+	rename map[string]string
+	drop   map[string]bool
+	// keep, when set, holds the only key properties reported
+	keep map[string]bool
 }
 
-// parseYaml reads a yaml file and parses it into a collectionDefinitionParser.
-// It validates syntax only and not content
+// derivedMetric is a storage struct containing the information
+// necessary to compute a metric from other attributes of a bean
+type derivedMetric struct {
+	metricName string
+	expression *expression
+	metricType metric.SourceType
+}
+
+// parseYaml reads a yaml file, expands its variable references and parses
+// it into a collectionDefinitionParser. It validates syntax only and not content
 func parseYaml(filename string) (*collectionDefinitionParser, error) {
 	// Read the file
 	yamlFile, err := ioutil.ReadFile(filename)
@@ -75,44 +277,220 @@ func parseYaml(filename string) (*collectionDefinitionParser, error) {
 		return nil, err
 	}
 
-	// Parse the file
-	var c collectionDefinitionParser
-	if err := yaml.Unmarshal(yamlFile, &c); err != nil {
+	// Parse the file, expanding the variable references of its values
+	c, err := decodeCollection(yamlFile, filename, lookupVariable)
+	if err != nil {
 		log.Error("failed to parse collection: %s", err)
 		return nil, err
 	}
-	return &c, nil
+	return c, nil
 }
 
-// parseJSON reads a json config and parses it into a collectionDefinitionParser.
-// It validates syntax only and not content
+// parseJSON reads the COLLECTION_CONFIG collection definition, expands its variable
+// references and parses it into a collectionDefinitionParser. It validates syntax only
+// and not content. The definition is JSON or yaml, optionally encoded as a base64: or
+// gzip+base64: payload.
 func parseJSON(collectionJSON string) (*collectionDefinitionParser, error) {
+	collectionJSON, err := decodeCollectionConfig(collectionJSON)
+	if err != nil {
+		log.Error("failed to decode collection config: %s", err)
+		return nil, fmt.Errorf("COLLECTION_CONFIG: %w", err)
+	}
+
+	// JSON is valid yaml, so decoding it as yaml reads both and gives us the
+	// strict checks and the position of every node. The few JSON documents the
+	// yaml parser can't read fall back to the plain JSON decoding.
+	strict, err := decodeCollection([]byte(collectionJSON), "COLLECTION_CONFIG", lookupVariable)
+	if problems, ok := err.(collectionErrors); ok {
+		log.Error("failed to parse JSON collection config: %s", problems)
+		return nil, problems
+	} else if err != nil {
+		c, jsonErr := decodeJSONCollection(collectionJSON)
+		if jsonErr != nil {
+			// Report the error of the format the config looks like
+			if !strings.HasPrefix(strings.TrimSpace(collectionJSON), "{") {
+				jsonErr = err
+			}
+			log.Error("failed to parse JSON collection config: %s", jsonErr)
+			return nil, jsonErr
+		}
+		log.Debug("Collection config can't be checked strictly: %s", err)
+		c.setSource("COLLECTION_CONFIG")
+		return c, nil
+	}
+	return strict, nil
+}
+
+// decodeJSONCollection decodes a JSON collection definition, expanding the
+// variable references of its values
+func decodeJSONCollection(collectionJSON string) (*collectionDefinitionParser, error) {
+	var document interface{}
+	if err := json.Unmarshal([]byte(collectionJSON), &document); err != nil {
+		return nil, err
+	}
+	document, err := expandJSONVariables(document, lookupVariable)
+	if err != nil {
+		return nil, fmt.Errorf("COLLECTION_CONFIG: %w", err)
+	}
+	expanded, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
 	var c collectionDefinitionParser
-	if err := json.Unmarshal([]byte(collectionJSON), &c); err != nil {
-		log.Error("failed to parse JSON collection config: %s", err)
+	if err := json.Unmarshal(expanded, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Prefixes of the encoded COLLECTION_CONFIG payloads, which keep big
+// collection definitions short and free of characters needing quotes
+const (
+	base64Prefix     = "base64:"
+	gzipBase64Prefix = "gzip+base64:"
+)
+
+// maxCollectionConfigSize limits the size of a decompressed COLLECTION_CONFIG
+const maxCollectionConfigSize = 16 << 20
+
+// decodeCollectionConfig decodes a base64: or gzip+base64: COLLECTION_CONFIG
+// payload, other values are returned as they are
+func decodeCollectionConfig(config string) (string, error) {
+	trimmed := strings.TrimSpace(config)
+	var payload string
+	var compressed bool
+	switch {
+	case strings.HasPrefix(trimmed, gzipBase64Prefix):
+		payload, compressed = strings.TrimPrefix(trimmed, gzipBase64Prefix), true
+	case strings.HasPrefix(trimmed, base64Prefix):
+		payload = strings.TrimPrefix(trimmed, base64Prefix)
+	default:
+		return config, nil
+	}
+
+	// Long payloads are often wrapped over several lines
+	payload = strings.Join(strings.Fields(payload), "")
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64 payload: %w", err)
+	}
+	if !compressed {
+		return string(data), nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("invalid gzip payload: %w", err)
+	}
+	defer reader.Close() // nolint: errcheck
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxCollectionConfigSize+1))
+	if err != nil {
+		return "", fmt.Errorf("invalid gzip payload: %w", err)
+	}
+	if len(decompressed) > maxCollectionConfigSize {
+		return "", fmt.Errorf("gzip payload is bigger than %d bytes", maxCollectionConfigSize)
+	}
+	return string(decompressed), nil
+}
+
+// parseCollectionBytes decodes a yaml collection definition and checks it
+// against the collection schema. Schema problems are kept in the definition
+// so parseCollectionDefinition reports them along with any other problem,
+// they are only returned here when the definition can't be decoded at all.
+func parseCollectionBytes(data []byte, source string) (*collectionDefinitionParser, error) {
+	return decodeCollection(data, source, nil)
+}
+
+// decodeCollection is parseCollectionBytes expanding the variable references of the
+// values of the definition with lookup, unless it is nil
+func decodeCollection(data []byte, source string, lookup func(name string) (string, bool)) (*collectionDefinitionParser, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
 		return nil, err
 	}
+	if lookup != nil {
+		if errs := expandNodeVariables(&document, source, lookup); len(errs) > 0 {
+			return nil, errs
+		}
+	}
+
+	var c collectionDefinitionParser
+	c.problems = checkSchema(&document, collectionSchema)
+	if err := document.Decode(&c); err != nil {
+		if len(c.problems) == 0 {
+			return nil, err
+		}
+		c.setSource(source)
+		return nil, c.problems
+	}
+	c.setSource(source)
 	return &c, nil
 }
 
 // parseCollection takes a raw collectionDefinitionParser and returns
-// an array of domains containing the validated configuration
+// an array of domains containing the validated configuration.
+// All the problems found are returned together as collectionErrors.
 func parseCollectionDefinition(c *collectionDefinitionParser) ([]*domainDefinition, error) {
-	var err error
+	errs := append(collectionErrors{}, c.problems...)
+
+	// Content errors of a block, bean or attribute that doesn't match the
+	// schema are most likely caused by it, so they are not reported twice
+	invalidScopes := make(map[position]bool, len(c.problems))
+	for _, problem := range c.problems {
+		invalidScopes[problem.scope] = true
+	}
+	addErrors := func(newErrs collectionErrors) {
+		for _, err := range newErrs {
+			if !invalidScopes[err.scope] {
+				errs = append(errs, err)
+			}
+		}
+	}
 
 	// For each domain in the collection
 	var collections []*domainDefinition
 	for _, domain := range c.Collect {
 
+		var entityName *nameTemplate
+		if domain.EntityName != "" {
+			var err error
+			entityName, err = parseBeanTemplate(domain.EntityName, "entity_name")
+			if err != nil {
+				addErrors(collectionErrors{{pos: domain.pos, scope: domain.pos, msg: fmt.Sprintf("invalid entity_name: %v", err)}})
+				continue
+			}
+		}
+
+		var when *expression
+		if domain.When != "" {
+			var err error
+			when, err = parseCondition(domain.When)
+			if err != nil {
+				addErrors(collectionErrors{{pos: domain.pos, scope: domain.pos, msg: fmt.Sprintf("invalid when: %v", err)}})
+				continue
+			}
+		}
+
 		// For each bean in the domain
 		var beans []*beanRequest
-		var newBean *beanRequest
 		for _, bean := range domain.Beans {
 
+			if bean.Remove {
+				addErrors(collectionErrors{{pos: bean.pos, scope: bean.pos, msg: fmt.Sprintf("no bean with query %s to remove, remove only applies to the beans of an extended collection file", bean.Query)}})
+				continue
+			}
+
 			// Parse the bean and add it to the domain
-			newBean, err = parseBean(&bean)
+			newBean, err := parseBean(&bean)
 			if err != nil {
-				return nil, err
+				addErrors(err.(collectionErrors))
+				continue
+			}
+			newBean.tags = mergeTags(domain.Tags, newBean.tags)
+			newBean.entityName, newBean.entityType = entityName, domain.EntityType
+			for _, attribute := range newBean.attributes {
+				attribute.typeInference = domain.TypeInference
 			}
 
 			beans = append(beans, newBean)
@@ -121,60 +499,275 @@ func parseCollectionDefinition(c *collectionDefinitionParser) ([]*domainDefiniti
 		// If no custom event type defined, generate an event type from the domain name
 		var eventType string
 		if domain.EventType == "" {
+			var err error
 			eventType, err = generateEventType(domain.Domain)
 			if err != nil {
-				return nil, err
+				addErrors(collectionErrors{{pos: domain.pos, scope: domain.pos, msg: err.Error()}})
+				continue
 			}
 		} else {
+			// Templates are resolved for each bean, they only need to be valid here
+			if isNameTemplate(domain.EventType) {
+				if _, err := parseBeanTemplate(domain.EventType, "event_type"); err != nil {
+					addErrors(collectionErrors{{pos: domain.pos, scope: domain.pos, msg: fmt.Sprintf("invalid event_type: %v", err)}})
+					continue
+				}
+			}
 			eventType = domain.EventType
 		}
-		collections = append(collections, &domainDefinition{domain: domain.Domain, eventType: eventType, beans: beans})
+		collections = append(collections, &domainDefinition{domain: domain.Domain, eventType: eventType, when: when, beans: beans})
 	}
 
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	return collections, nil
 }
 
+// parseBean validates a bean definition. Every problem found
+// is returned as collectionErrors
 func parseBean(bean *beanDefinitionParser) (*beanRequest, error) {
-	attributes, err := parseAttributes(bean.Attributes)
-	if err != nil {
-		return nil, err
+	var errs collectionErrors
+
+	attributes, err := parseAttributes(bean)
+	errs.add(bean.pos, err)
+
+	// Parse the exclude patterns. exclude_regex matches the whole domain:bean,attr=name
+	// string, or only the domain:bean ObjectName from version 2, while the others match
+	// a single component of it
+	excludePatterns, err := parseRegexList(bean.Exclude, "exclude_regex", bean.pos)
+	errs.add(bean.pos, err)
+	excludeDomains, err := parseRegexList(bean.ExcludeDomains, "exclude_domains", bean.pos)
+	errs.add(bean.pos, err)
+	excludeBeans, err := parseRegexList(bean.ExcludeBeans, "exclude_beans", bean.pos)
+	errs.add(bean.pos, err)
+	excludeAttributes, err := parseRegexList(bean.ExcludeAttributes, "exclude_attributes", bean.pos)
+	errs.add(bean.pos, err)
+
+	// Parse the include filters
+	var includePatterns map[string]*regexp.Regexp
+	for key, pattern := range bean.Include {
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			errs.add(bean.pos, fmt.Errorf("invalid include regex pattern %s for key property %s", pattern, key))
+			continue
+		}
+		if includePatterns == nil {
+			includePatterns = make(map[string]*regexp.Regexp, len(bean.Include))
+		}
+		includePatterns[key] = r
 	}
 
-	// Parse the exclude patterns
-	var excludePatterns []*regexp.Regexp
-	if bean.Exclude != nil {
-		switch b := bean.Exclude.(type) {
-		// If exclude_regex is a string
-		case string:
-			r, err := regexp.Compile(b)
-			if err != nil {
-				return nil, fmt.Errorf("invalid regex pattern %s", b)
+	var queryRegexp *regexp.Regexp
+	if bean.QueryRegex != "" {
+		queryRegexp, err = regexp.Compile(bean.QueryRegex)
+		if err != nil {
+			errs.add(bean.pos, fmt.Errorf("invalid query_regex pattern %s", bean.QueryRegex))
+		}
+	}
+
+	derived, err := parseDerived(bean.Derived)
+	errs.add(bean.pos, err)
+
+	var when *expression
+	if bean.When != "" {
+		when, err = parseCondition(bean.When)
+		if err != nil {
+			errs.add(bean.pos, fmt.Errorf("invalid when: %w", err))
+		}
+	}
+
+	keyProperties, err := parseKeyProperties(bean.KeyProperties, bean.pos)
+	errs.add(bean.pos, err)
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &beanRequest{
+		beanQuery:         bean.Query,
+		exclude:           excludePatterns,
+		excludeObjectName: bean.version >= 2,
+		excludeDomains:    excludeDomains,
+		excludeBeans:      excludeBeans,
+		excludeAttributes: excludeAttributes,
+		include:           includePatterns,
+		queryRegexp:       queryRegexp,
+		attributes:        attributes,
+		derived:           derived,
+		keyProperties:     keyProperties,
+		tags:              bean.Tags,
+		when:              when,
+	}, nil
+}
+
+// parseKeyProperties validates the key_properties block of the bean at pos.
+// drop and keep can't be used together, and renamed key properties must be reported.
+func parseKeyProperties(k *keyPropertiesParser, pos position) (*keyPropertiesRule, error) {
+	if k == nil {
+		return nil, nil
+	}
+	if len(k.Drop) > 0 && len(k.Keep) > 0 {
+		return nil, fmt.Errorf("key_properties can't have both drop and keep")
+	}
+
+	rule := &keyPropertiesRule{rename: k.Rename}
+	if len(k.Drop) > 0 {
+		rule.drop = make(map[string]bool, len(k.Drop))
+		for _, key := range k.Drop {
+			rule.drop[key] = true
+		}
+	}
+	if len(k.Keep) > 0 {
+		rule.keep = make(map[string]bool, len(k.Keep))
+		for _, key := range k.Keep {
+			rule.keep[key] = true
+		}
+	}
+
+	var errs collectionErrors
+	renamed := make([]string, 0, len(k.Rename))
+	for key := range k.Rename {
+		renamed = append(renamed, key)
+	}
+	sort.Strings(renamed)
+	for _, key := range renamed {
+		if k.Rename[key] == "" {
+			errs.add(pos, fmt.Errorf("key_properties can't rename %s to an empty name", key))
+		} else if _, reported := rule.attributeName(key); !reported {
+			errs.add(pos, fmt.Errorf("key_properties can't rename %s, it isn't reported", key))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return rule, nil
+}
+
+// attributeName returns the name of the attribute the key property is reported as,
+// or false when it isn't reported
+func (r *keyPropertiesRule) attributeName(key string) (string, bool) {
+	if r == nil {
+		return "key:" + key, true
+	}
+	if r.drop[key] || (r.keep != nil && !r.keep[key]) {
+		return "", false
+	}
+	if name, ok := r.rename[key]; ok {
+		return name, true
+	}
+	return "key:" + key, true
+}
+
+// mergeTags merges the custom attribute maps, the values of the
+// later ones overriding the earlier ones. It returns nil when all are empty.
+func mergeTags(tagMaps ...map[string]string) map[string]string {
+	var merged map[string]string
+	for _, tags := range tagMaps {
+		for key, value := range tags {
+			if merged == nil {
+				merged = make(map[string]string)
 			}
-			excludePatterns = append(excludePatterns, r)
-		// If exclude_regex is an array of strings
-		case []interface{}:
-			for _, excludeString := range b {
-				switch e := excludeString.(type) {
-				case string:
-					// Panic if can't compile the regex pattern
-					r := regexp.MustCompile(e)
-					excludePatterns = append(excludePatterns, r)
-				default:
-					return nil, fmt.Errorf("invalid exclude pattern '%v'", e)
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// parseCustomAttributes parses the CUSTOM_ATTRIBUTES argument,
+// a JSON object of string values like {"team":"payments"}
+func parseCustomAttributes(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var customAttributes map[string]string
+	if err := json.Unmarshal([]byte(raw), &customAttributes); err != nil {
+		return nil, fmt.Errorf("invalid custom attributes, must be a JSON object of string values: %w", err)
+	}
+	return customAttributes, nil
+}
+
+// parseRegexList compiles the patterns of an exclusion option of the bean at pos,
+// which can either be a single pattern or a list of them
+func parseRegexList(raw interface{}, option string, pos position) ([]*regexp.Regexp, error) {
+	var errs collectionErrors
+	var patterns []*regexp.Regexp
+	switch b := raw.(type) {
+	case nil:
+	// If it's a string
+	case string:
+		r, err := regexp.Compile(b)
+		if err != nil {
+			errs.add(pos, fmt.Errorf("invalid regex pattern %s", b))
+			break
+		}
+		patterns = append(patterns, r)
+	// If it's an array of strings
+	case []interface{}:
+		for _, patternString := range b {
+			switch e := patternString.(type) {
+			case string:
+				r, err := regexp.Compile(e)
+				if err != nil {
+					errs.add(pos, fmt.Errorf("invalid regex pattern %s", e))
+					continue
 				}
+				patterns = append(patterns, r)
+			default:
+				errs.add(pos, fmt.Errorf("invalid exclude pattern '%v'", e))
 			}
-		default:
-			return nil, fmt.Errorf("invalid format for exclude_regex")
+		}
+	default:
+		errs.add(pos, fmt.Errorf("invalid format for %s", option))
+	}
 
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return patterns, nil
+}
+
+func parseDerived(rawDerived []derivedDefinitionParser) ([]*derivedMetric, error) {
+	var errs collectionErrors
+	var derived []*derivedMetric
+	for _, d := range rawDerived {
+		if d.MetricName == "" || d.Expression == "" {
+			errs.add(d.pos, fmt.Errorf("derived metrics must specify metric_name and expression"))
+			continue
 		}
+
+		expr, err := parseExpression(d.Expression)
+		if err != nil {
+			errs.add(d.pos, err)
+			continue
+		}
+		if calls := contextCalls(expr); len(calls) > 0 {
+			errs.add(d.pos, fmt.Errorf("invalid expression %q: %s() can only be used in when conditions", d.Expression, calls[0]))
+			continue
+		}
+
+		metricType := metric.GAUGE
+		if d.MetricType != "" {
+			mt, ok := metric.SourcesNameToType[d.MetricType]
+			if !ok {
+				errs.add(d.pos, fmt.Errorf("invalid metric type %s", d.MetricType))
+				continue
+			}
+			metricType = mt
+		}
+
+		derived = append(derived, &derivedMetric{metricName: d.MetricName, expression: expr, metricType: metricType})
 	}
-	return &beanRequest{beanQuery: bean.Query, exclude: excludePatterns, attributes: attributes}, nil
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return derived, nil
 }
 
-func parseAttributes(rawAttributes []interface{}) ([]*attributeRequest, error) {
+func parseAttributes(bean *beanDefinitionParser) ([]*attributeRequest, error) {
 	var attributes []*attributeRequest
 	// If no attributes are specified, collect all
-	if len(rawAttributes) == 0 {
+	if len(bean.Attributes) == 0 {
 		// We know this is valid regex, so we don't need to handle the error
 		r, _ := createAttributeRegex(".*", false)
 		attributes = []*attributeRequest{
@@ -184,8 +777,9 @@ func parseAttributes(rawAttributes []interface{}) ([]*attributeRequest, error) {
 			},
 		}
 	} else {
+		var errs collectionErrors
 		// For each defined attribute
-		for _, attribute := range rawAttributes {
+		for i, attribute := range bean.Attributes {
 			var newAttribute *attributeRequest
 			var err error
 			switch a := attribute.(type) {
@@ -196,13 +790,17 @@ func parseAttributes(rawAttributes []interface{}) ([]*attributeRequest, error) {
 			case string:
 				newAttribute, err = parseAttributeFromString(a)
 			default:
-				return nil, fmt.Errorf("unable to parse attributes list %s", attribute)
+				err = fmt.Errorf("unable to parse attributes list %v", attribute)
 			}
 			if err != nil {
-				return nil, err
+				errs.add(bean.attributePosition(i), err)
+				continue
 			}
 			attributes = append(attributes, newAttribute)
 		}
+		if len(errs) > 0 {
+			return nil, errs
+		}
 	}
 	return attributes, nil
 }
@@ -231,11 +829,34 @@ func parseAttributeFromString(a string) (*attributeRequest, error) {
 	return &attributeRequest{attrRegexp: attrRegexp, metricType: -1}, nil
 }
 
+// stringOption returns the value of a string option of an attribute definition,
+// and whether it is set. Empty options are the same as absent ones.
+func stringOption(a map[string]interface{}, key string) (string, bool, error) {
+	raw := a[key]
+	if raw == nil {
+		return "", false, nil
+	}
+	value, ok := raw.(string)
+	if !ok {
+		return "", true, fmt.Errorf("%s: expected a string", key)
+	}
+	return value, true, nil
+}
+
 func parseAttributeFromMap(a map[string]interface{}) (*attributeRequest, error) {
-	attrName, namePresent := a["attr"]
-	attrRegexpString, regexPresent := a["attr_regex"]
+	attrName, namePresent, err := stringOption(a, "attr")
+	if err != nil {
+		return nil, err
+	}
+	attrRegexpString, regexPresent, err := stringOption(a, "attr_regex")
+	if err != nil {
+		return nil, err
+	}
+	metricName, _, err := stringOption(a, "metric_name")
+	if err != nil {
+		return nil, err
+	}
 	var attrRegexp *regexp.Regexp
-	var err error
 
 	// Must specify exactly one attribute selector
 	if namePresent == regexPresent {
@@ -243,46 +864,86 @@ func parseAttributeFromMap(a map[string]interface{}) (*attributeRequest, error)
 	}
 
 	if regexPresent {
-		attrRegexp, err = createAttributeRegex(attrRegexpString.(string), false)
+		attrRegexp, err = createAttributeRegex(attrRegexpString, false)
 		if err != nil {
 			return nil, fmt.Errorf("failed to compile attribute regex pattern %s", attrRegexpString)
 		}
 	} else {
-		attrRegexp, err = createAttributeRegex(attrName.(string), true)
+		attrRegexp, err = createAttributeRegex(attrName, true)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create regex pattern from attribute name %s", attrName.(string))
+			return nil, fmt.Errorf("failed to create regex pattern from attribute name %s", attrName)
 		}
 	}
 
+	// Parse the composite keys and table options, which select
+	// parts of the attribute instead of the attribute itself
+	keys, table, err := parseCompositeOptions(a)
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil || table != nil {
+		attrRegexp, err = createCompositeRegex(attrName, keys, table)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create regex pattern from attribute name %s", attrName)
+		}
+	}
+
+	// Parse the array handling
+	array, err := parseArrayOption(a)
+	if err != nil {
+		return nil, err
+	}
+
 	// Parse the metric type
 	metricType, err := getMetricType(a)
 	if err != nil {
 		return nil, err
 	}
 
+	// Parse the value conversion
+	transform, err := parseValueTransform(a)
+	if err != nil {
+		return nil, err
+	}
+
 	newAttribute := &attributeRequest{
 		attrRegexp: attrRegexp,
 		metricType: metricType,
+		transform:  transform,
+		keys:       keys,
+		table:      table,
+		array:      array,
+	}
+	if keys != nil || table != nil {
+		newAttribute.attrName = attrName
 	}
 
 	// Parse the metric name
-	metricName := a["metric_name"]
-	if metricName != nil {
-		newAttribute.metricName = metricName.(string)
+	if metricName != "" {
+		newAttribute.metricName = metricName
+		if isNameTemplate(newAttribute.metricName) {
+			newAttribute.metricNameTemplate, err = parseNameTemplate(newAttribute.metricName)
+			if err != nil {
+				return nil, fmt.Errorf("invalid metric_name: %w", err)
+			}
+		}
 	}
 
 	return newAttribute, nil
 }
 
 func getMetricType(a map[string]interface{}) (metric.SourceType, error) {
-	metricTypeString, ok := a["metric_type"]
+	metricTypeString, ok, err := stringOption(a, "metric_type")
+	if err != nil {
+		return 0, err
+	}
 	var metricType metric.SourceType
 	if !ok {
 		metricType = -1 // Since metric type can't be nil, using -1 as a placeholder
 	} else {
-		mt, ok := metric.SourcesNameToType[metricTypeString.(string)]
+		mt, ok := metric.SourcesNameToType[metricTypeString]
 		if !ok {
-			return 0, fmt.Errorf("invalid metric type %s", metricTypeString.(string))
+			return 0, fmt.Errorf("invalid metric type %s", metricTypeString)
 		}
 		metricType = mt
 	}
@@ -6,6 +6,9 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"fmt"
 	"reflect"
 	"regexp"
@@ -15,6 +18,7 @@ import (
 
 	"github.com/kr/pretty"
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	yaml "gopkg.in/yaml.v3"
 )
 
 func TestParseYaml(t *testing.T) {
@@ -94,6 +98,11 @@ func TestParseAttributeFromMap(t *testing.T) {
 			&attributeRequest{attrRegexp: regexp.MustCompile("attr=testattr$"), metricType: metric.DELTA},
 			true,
 		},
+		{
+			map[string]interface{}{"attr": "testattr", "metric_name": "test.{name"},
+			nil,
+			true,
+		},
 	}
 
 	for i, tc := range testCases {
@@ -111,6 +120,34 @@ func TestParseAttributeFromMap(t *testing.T) {
 	}
 }
 
+func TestParseCollectionDefinition_WrongAttributeTypes(t *testing.T) {
+	testCases := []struct {
+		attribute string
+		expected  string
+	}{
+		{"{attr: 42}", `file:5:29: attr: expected a string, got int "42"`},
+		{"{attr_regex: 1}", `file:5:35: attr_regex: expected a string, got int "1"`},
+		{"{attr: Count, metric_name: 5}", `file:5:49: metric_name: expected a string, got int "5"`},
+		{"{attr: Count, metric_type: 3}", `file:5:49: metric_type: expected a string, got int "3"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.attribute, func(t *testing.T) {
+			data := "collect:\n  - domain: a\n    beans:\n      - query: '*'\n        attributes: [" + tc.attribute + "]\n"
+			c, err := parseCollectionBytes([]byte(data), "file")
+			assert.NoError(t, err)
+
+			_, err = parseCollectionDefinition(c)
+			assert.EqualError(t, err, tc.expected)
+
+			a := map[string]interface{}{}
+			assert.NoError(t, yaml.Unmarshal([]byte(tc.attribute), &a))
+			_, err = parseAttributeFromMap(a)
+			assert.Error(t, err)
+		})
+	}
+}
+
 func TestParseBean(t *testing.T) {
 	testCases := []struct {
 		input        *beanDefinitionParser
@@ -218,6 +255,15 @@ func TestParseBean(t *testing.T) {
 	}
 }
 
+func TestParseBean_InvalidFilters(t *testing.T) {
+	_, err := parseBean(&beanDefinitionParser{
+		Query:      "type=ThreadPool,*",
+		QueryRegex: "(",
+		Include:    map[string]string{"name": "["},
+	})
+	assert.EqualError(t, err, "invalid include regex pattern [ for key property name\ninvalid query_regex pattern (")
+}
+
 func TestParseCollectionDefinitionJSON(t *testing.T) {
 	configJSON := `
           {
@@ -350,3 +396,233 @@ func TestParseCollectionDefinition_Fail(t *testing.T) {
 		t.Error("Expected error")
 	}
 }
+
+func TestParseCollectionDefinition_Strict(t *testing.T) {
+	file := "../test/data/test-sample-strict.yml"
+	c, err := parseYaml(file)
+	assert.NoError(t, err)
+
+	_, err = parseCollectionDefinition(c)
+	assert.Error(t, err)
+
+	var messages []string
+	for _, e := range err.(collectionErrors) {
+		messages = append(messages, e.Error())
+	}
+	assert.Equal(t, []string{
+		file + `:3:7: unknown key "evnt_type" in collect block (did you mean "event_type"?)`,
+		file + `:6:13: unknown key "attribute" in bean definition (did you mean "attributes"?)`,
+		file + `:11:32: metric_type: invalid value "counter", expected one of attribute, delta, gauge, pdelta, prate, rate`,
+		file + `:13:19: unknown key "metric_nam" in attribute (did you mean "metric_name"?)`,
+		file + `:14:19: attributes: expected a string or a map, got int "42"`,
+		file + `:19:17: exclude_regex: expected a string or a list, got a map`,
+		file + `:15:7: cannot generate event type for wildcarded domain test.*`,
+	}, messages)
+}
+
+func TestParseJSON_Strict(t *testing.T) {
+	configJSON := `{"collect": [{"domain": "com.demo.app", "beans": [{"query": "name=Status", "attribute": ["Random"]}]}]}`
+
+	c, err := parseJSON(configJSON)
+	assert.NoError(t, err)
+
+	_, err = parseCollectionDefinition(c)
+	assert.EqualError(t, err, `COLLECTION_CONFIG:1:76: unknown key "attribute" in bean definition (did you mean "attributes"?)`)
+}
+
+func TestParseCollectionDefinition_Tags(t *testing.T) {
+	configJSON := `{"collect": [{"domain": "com.demo.app", "tags": {"team": "payments", "tier": "backend"},
+		"beans": [{"query": "name=Status", "tags": {"tier": "frontend"}}, {"query": "name=Other"}]}]}`
+
+	c, err := parseJSON(configJSON)
+	assert.NoError(t, err)
+
+	domains, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "tier": "frontend"}, domains[0].beans[0].tags)
+	assert.Equal(t, map[string]string{"team": "payments", "tier": "backend"}, domains[0].beans[1].tags)
+}
+
+func TestParseCollectionDefinition_Merge(t *testing.T) {
+	c, err := parseCollectionBytes([]byte(`collect:
+  - domain: Catalina
+    beans:
+      - &cache
+        query: type=Cache,*
+        tags:
+          tier: one
+      - <<: *cache
+        tags:
+          tier: two
+`), "file")
+	assert.NoError(t, err)
+	domains, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "type=Cache,*", domains[0].beans[1].beanQuery)
+	assert.Equal(t, map[string]string{"tier": "two"}, domains[0].beans[1].tags)
+
+	// The keys of the mapping itself are still checked
+	c, err = parseCollectionBytes([]byte(`collect:
+  - domain: Catalina
+    beans:
+      - &cache
+        query: type=Cache,*
+      - <<: [*cache]
+        qurey: type=Other
+`), "file")
+	assert.NoError(t, err)
+	_, err = parseCollectionDefinition(c)
+	assert.EqualError(t, err, `file:7:9: unknown key "qurey" in bean definition (did you mean "query"?)`)
+}
+
+func TestParseCollectionDefinition_EventTypeTemplate(t *testing.T) {
+	c, err := parseJSON(`{"collect": [{"domain": "kafka.*", "event_type": "{domain|title}Sample", "beans": [{"query": "*"}]}]}`)
+	assert.NoError(t, err)
+	domains, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "{domain|title}Sample", domains[0].eventType)
+
+	c, err = parseJSON(`{"collect": [{"domain": "kafka.*", "event_type": "{domain|camel}Sample", "beans": [{"query": "*"}]}]}`)
+	assert.NoError(t, err)
+	_, err = parseCollectionDefinition(c)
+	assert.ErrorContains(t, err, `invalid event_type: invalid template "{domain|camel}Sample": unknown filter "camel"`)
+}
+
+func TestParseCollectionDefinition_Entity(t *testing.T) {
+	c, err := parseJSON(`{"collect": [{"domain": "kafka.*", "event_type": "KafkaSample", "entity_name": "{host}-{key:type}", "entity_type": "kafka",
+		"beans": [{"query": "*"}]}]}`)
+	assert.NoError(t, err)
+	domains, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "{host}-{key:type}", domains[0].beans[0].entityName.raw)
+	assert.Equal(t, "kafka", domains[0].beans[0].entityType)
+
+	c, err = parseJSON(`{"collect": [{"domain": "kafka.*", "event_type": "KafkaSample", "entity_name": "{attr}", "beans": [{"query": "*"}]}]}`)
+	assert.NoError(t, err)
+	_, err = parseCollectionDefinition(c)
+	assert.ErrorContains(t, err, "invalid entity_name")
+}
+
+func TestParseCollectionDefinition_Version(t *testing.T) {
+	c, err := parseCollectionBytes([]byte("version: 3\ncollect:\n  - domain: a\n    beans:\n      - query: '*'\n"), "test.yml")
+	assert.NoError(t, err)
+	_, err = parseCollectionDefinition(c)
+	assert.EqualError(t, err, `test.yml:1:10: version: invalid value "3", expected one of 1, 2`)
+
+	c, err = parseJSON(`{"version": 2, "collect": [{"domain": "a", "beans": [{"query": "*", "exclude_regex": "type=Cache"}]}]}`)
+	assert.NoError(t, err)
+	domains, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+	assert.True(t, domains[0].beans[0].excludeObjectName)
+}
+
+func TestParseCollectionDefinition_TypeInference(t *testing.T) {
+	c, err := parseJSON(`{"collect": [{"domain": "java.lang", "type_inference": "heuristic", "beans": [{"query": "type=GarbageCollector,*", "attributes": ["CollectionCount", {"attr": "CollectionTime", "metric_type": "gauge"}]}]}]}`)
+	assert.NoError(t, err)
+	domains, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+	assert.Equal(t, typeInferenceHeuristic, domains[0].beans[0].attributes[0].typeInference)
+
+	c, err = parseCollectionBytes([]byte("collect:\n  - domain: a\n    type_inference: guess\n    beans:\n      - query: '*'\n"), "test.yml")
+	assert.NoError(t, err)
+	_, err = parseCollectionDefinition(c)
+	assert.ErrorContains(t, err, `type_inference: invalid value "guess"`)
+}
+
+func TestParseJSON_Encoded(t *testing.T) {
+	config := `{"collect": [{"domain": "java.lang", "event_type": "JVMSample", "beans": [{"query": "type=Threading"}]}]}`
+	yamlConfig := "collect:\n  - domain: java.lang\n    event_type: JVMSample\n    beans:\n      - query: type=Threading\n"
+
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	_, err := writer.Write([]byte(yamlConfig))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	for _, payload := range []string{
+		config,
+		yamlConfig,
+		"base64:" + base64.StdEncoding.EncodeToString([]byte(config)),
+		"gzip+base64:" + base64.StdEncoding.EncodeToString(compressed.Bytes()),
+	} {
+		c, err := parseJSON(payload)
+		if assert.NoError(t, err, payload) {
+			assert.Equal(t, "java.lang", c.Collect[0].Domain)
+			assert.Equal(t, "type=Threading", c.Collect[0].Beans[0].Query)
+		}
+	}
+
+	_, err = parseJSON("base64:not base64!")
+	assert.ErrorContains(t, err, "COLLECTION_CONFIG: invalid base64 payload")
+	_, err = parseJSON("gzip+base64:" + base64.StdEncoding.EncodeToString([]byte(config)))
+	assert.ErrorContains(t, err, "COLLECTION_CONFIG: invalid gzip payload")
+	_, err = parseJSON(`{"collect": [`)
+	assert.Error(t, err)
+}
+
+func TestParseCollectionDefinition_When(t *testing.T) {
+	c, err := parseJSON(`{"collect": [{"domain": "java.lang", "event_type": "JVMSample", "when": "major(SpecVersion) >= 11",
+		"beans": [{"query": "type=GarbageCollector,name=ZGC*", "when": "has_bean('java.lang:type=GarbageCollector,name=ZGC*')"}]}]}`)
+	assert.NoError(t, err)
+	domains, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "major(SpecVersion) >= 11", domains[0].when.raw)
+	assert.Equal(t, "has_bean('java.lang:type=GarbageCollector,name=ZGC*')", domains[0].beans[0].when.raw)
+
+	c, err = parseCollectionBytes([]byte(`collect:
+  - domain: a
+    when: SpecVersion >=
+    beans:
+      - query: '*'
+  - domain: b
+    beans:
+      - query: '*'
+        when: Uptime > 0
+        derived:
+          - metric_name: zgc
+            expression: has_bean('b:*')
+`), "test.yml")
+	assert.NoError(t, err)
+	_, err = parseCollectionDefinition(c)
+	assert.ErrorContains(t, err, "test.yml:2:5: invalid when")
+	assert.ErrorContains(t, err, "unknown reference Uptime")
+	assert.ErrorContains(t, err, "has_bean() can only be used in when conditions")
+}
+
+func TestParseCustomAttributes(t *testing.T) {
+	customAttributes, err := parseCustomAttributes(`{"team": "payments", "service": "checkout"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "service": "checkout"}, customAttributes)
+
+	customAttributes, err = parseCustomAttributes("")
+	assert.NoError(t, err)
+	assert.Nil(t, customAttributes)
+
+	_, err = parseCustomAttributes(`{"tier": 1}`)
+	assert.Error(t, err)
+}
+
+func TestParseKeyProperties(t *testing.T) {
+	rule, err := parseKeyProperties(&keyPropertiesParser{Rename: map[string]string{"name": "poolName"}, Drop: []string{"context"}}, position{})
+	assert.NoError(t, err)
+
+	name, ok := rule.attributeName("name")
+	assert.True(t, ok)
+	assert.Equal(t, "poolName", name)
+	name, ok = rule.attributeName("type")
+	assert.True(t, ok)
+	assert.Equal(t, "key:type", name)
+	_, ok = rule.attributeName("context")
+	assert.False(t, ok)
+
+	rule, err = parseKeyProperties(&keyPropertiesParser{Keep: []string{"type"}}, position{})
+	assert.NoError(t, err)
+	_, ok = rule.attributeName("name")
+	assert.False(t, ok)
+
+	_, err = parseKeyProperties(&keyPropertiesParser{Drop: []string{"name"}, Keep: []string{"type"}}, position{})
+	assert.EqualError(t, err, "key_properties can't have both drop and keep")
+
+	_, err = parseKeyProperties(&keyPropertiesParser{Rename: map[string]string{"name": "poolName"}, Keep: []string{"type"}}, position{})
+	assert.EqualError(t, err, "key_properties can't rename name, it isn't reported")
+}
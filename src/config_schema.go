@@ -0,0 +1,464 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// schemaKind is the shape a value of a collection definition is expected to have
+type schemaKind int
+
+const (
+	schemaString schemaKind = iota
+	schemaNumber
+	schemaBool
+	schemaList
+	schemaMap
+	schemaOneOf
+)
+
+// schemaNode describes the expected shape of a value of a collection
+// definition. It is used to strictly check collection files before they
+// are decoded, so unknown keys and wrong types are reported instead of
+// silently ignored
+type schemaNode struct {
+	kind schemaKind
+	// name is used in error messages and marks the node as a scope,
+	// meaning errors found in it hide content errors for the same node
+	name string
+	// fields are the keys accepted by a schemaMap node
+	fields map[string]*schemaNode
+	// required are the keys a schemaMap node must define
+	required []string
+	// items is the schema of the elements of a schemaList node, and of the
+	// values of a schemaMap node accepting any key, which has no fields
+	items *schemaNode
+	// oneOf are the alternatives of a schemaOneOf node, picked by node kind
+	oneOf []*schemaNode
+	// enum restricts the values of a schemaString or schemaNumber node
+	enum []string
+	// description documents the node in the exported JSON Schema
+	description string
+}
+
+var (
+	stringSchema = &schemaNode{kind: schemaString}
+
+	numberSchema = &schemaNode{kind: schemaNumber}
+
+	boolSchema = &schemaNode{kind: schemaBool}
+
+	stringListSchema = &schemaNode{kind: schemaList, items: stringSchema}
+
+	stringMapSchema = &schemaNode{kind: schemaMap, items: stringSchema}
+
+	stringOrListSchema = &schemaNode{kind: schemaOneOf, oneOf: []*schemaNode{stringSchema, stringListSchema}}
+
+	attributeSchema = &schemaNode{
+		kind: schemaOneOf,
+		oneOf: []*schemaNode{
+			stringSchema,
+			{
+				kind:        schemaMap,
+				name:        "attribute",
+				description: "An attribute to collect, selected by name with attr or by pattern with attr_regex",
+				fields: map[string]*schemaNode{
+					"attr":        stringSchema,
+					"attr_regex":  stringSchema,
+					"metric_name": stringSchema,
+					"metric_type": {kind: schemaString, enum: metricTypeNames()},
+					"scale":       numberSchema,
+					"offset":      numberSchema,
+					"unit":        stringSchema,
+					"to_unit":     stringSchema,
+					"keys":        stringListSchema,
+					"table":       tableSchema,
+					"array":       {kind: schemaString, enum: []string{arraySummary, arrayIndex}},
+				},
+			},
+		},
+	}
+
+	tableSchema = &schemaNode{
+		kind:        schemaMap,
+		name:        "table",
+		description: "Reports each row of a TabularData attribute as its own sample, with the row key as the index attribute",
+		required:    []string{"index"},
+		fields: map[string]*schemaNode{
+			"index": stringSchema,
+		},
+	}
+
+	keyPropertiesSchema = &schemaNode{
+		kind:        schemaMap,
+		name:        "key_properties",
+		description: "How the key properties of the bean ObjectName are reported, by default all of them as key:<name>",
+		fields: map[string]*schemaNode{
+			"rename": stringMapSchema,
+			"drop":   stringListSchema,
+			"keep":   stringListSchema,
+		},
+	}
+
+	derivedSchema = &schemaNode{
+		kind:        schemaMap,
+		name:        "derived metric",
+		description: "A metric computed from other attributes of the bean",
+		required:    []string{"metric_name", "expression"},
+		fields: map[string]*schemaNode{
+			"metric_name": stringSchema,
+			"expression":  stringSchema,
+			"metric_type": {kind: schemaString, enum: metricTypeNames()},
+		},
+	}
+
+	beanSchema = &schemaNode{
+		kind:        schemaMap,
+		name:        "bean definition",
+		description: "The beans of the domain matching query, and the attributes collected from them",
+		required:    []string{"query"},
+		fields: map[string]*schemaNode{
+			"query":              stringSchema,
+			"query_regex":        stringSchema,
+			"when":               stringSchema,
+			"include":            stringMapSchema,
+			"exclude_regex":      stringOrListSchema,
+			"exclude_domains":    stringOrListSchema,
+			"exclude_beans":      stringOrListSchema,
+			"exclude_attributes": stringOrListSchema,
+			"attributes":         {kind: schemaList, items: attributeSchema},
+			"derived":            {kind: schemaList, items: derivedSchema},
+			"tags":               stringMapSchema,
+			"key_properties":     keyPropertiesSchema,
+			"remove":             boolSchema,
+		},
+	}
+
+	collectBlockSchema = &schemaNode{
+		kind:        schemaMap,
+		name:        "collect block",
+		description: "The beans collected from a domain, which can be a JMX pattern like kafka.*",
+		required:    []string{"domain"},
+		fields: map[string]*schemaNode{
+			"domain":         stringSchema,
+			"event_type":     stringSchema,
+			"when":           stringSchema,
+			"entity_name":    stringSchema,
+			"entity_type":    stringSchema,
+			"type_inference": {kind: schemaString, enum: typeInferenceModes},
+			"tags":           stringMapSchema,
+			"beans":          {kind: schemaList, items: beanSchema},
+		},
+	}
+
+	collectionSchema = &schemaNode{
+		kind:        schemaMap,
+		name:        "collection definition",
+		description: "An nri-jmx collection file, listing the domains, beans and attributes to collect",
+		fields: map[string]*schemaNode{
+			"version": {kind: schemaNumber, enum: collectionVersions},
+			"include": stringListSchema,
+			"extends": stringSchema,
+			"collect": {kind: schemaList, items: collectBlockSchema},
+		},
+	}
+)
+
+// metricTypeNames returns the sorted list of metric types accepted by metric_type
+func metricTypeNames() []string {
+	names := make([]string, 0, len(metric.SourcesNameToType))
+	for name := range metric.SourcesNameToType {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// position locates a node of a collection definition
+type position struct {
+	file   string
+	line   int
+	column int
+}
+
+func nodePosition(node *yaml.Node) position {
+	return position{line: node.Line, column: node.Column}
+}
+
+func (p position) String() string {
+	switch {
+	case p.line == 0:
+		return p.file
+	case p.file == "":
+		return fmt.Sprintf("%d:%d", p.line, p.column)
+	default:
+		return fmt.Sprintf("%s:%d:%d", p.file, p.line, p.column)
+	}
+}
+
+// collectionError is a single problem found in a collection definition
+type collectionError struct {
+	pos position
+	// scope is the position of the bean, attribute or block the problem was found in
+	scope position
+	msg   string
+}
+
+func (e *collectionError) Error() string {
+	if loc := e.pos.String(); loc != "" {
+		return loc + ": " + e.msg
+	}
+	return e.msg
+}
+
+// collectionErrors is the list of every problem found in a collection
+// definition, so they can all be reported at once
+type collectionErrors []*collectionError
+
+func (e collectionErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// add appends err to the list, keeping the location of collectionErrors
+// and using pos for any other error
+func (e *collectionErrors) add(pos position, err error) {
+	switch ce := err.(type) {
+	case nil:
+	case *collectionError:
+		*e = append(*e, ce)
+	case collectionErrors:
+		*e = append(*e, ce...)
+	default:
+		*e = append(*e, &collectionError{pos: pos, scope: pos, msg: err.Error()})
+	}
+}
+
+// checkSchema walks a yaml document and returns every place where it does
+// not match schema
+func checkSchema(document *yaml.Node, schema *schemaNode) collectionErrors {
+	var errs collectionErrors
+	root := document
+	if root.Kind == yaml.DocumentNode {
+		if len(root.Content) == 0 {
+			return nil
+		}
+		root = root.Content[0]
+	}
+	checkNode(root, schema, "", nodePosition(root), &errs)
+	return errs
+}
+
+func checkNode(node *yaml.Node, schema *schemaNode, key string, scope position, errs *collectionErrors) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	// An empty value is the same as an absent one
+	if node.Tag == "!!null" {
+		return
+	}
+
+	fail := func(format string, a ...interface{}) {
+		msg := fmt.Sprintf(format, a...)
+		if key != "" {
+			msg = fmt.Sprintf("%s: %s", key, msg)
+		}
+		*errs = append(*errs, &collectionError{pos: nodePosition(node), scope: scope, msg: msg})
+	}
+
+	if schema.kind == schemaOneOf {
+		for _, alternative := range schema.oneOf {
+			if kindMatches(node, alternative.kind) {
+				checkNode(node, alternative, key, scope, errs)
+				return
+			}
+		}
+		kinds := make([]string, 0, len(schema.oneOf))
+		for _, alternative := range schema.oneOf {
+			kinds = append(kinds, kindName(alternative.kind))
+		}
+		fail("expected %s, got %s", strings.Join(kinds, " or "), nodeKindName(node))
+		return
+	}
+
+	if !kindMatches(node, schema.kind) {
+		fail("expected %s, got %s", kindName(schema.kind), nodeKindName(node))
+		return
+	}
+
+	switch schema.kind {
+	case schemaString, schemaNumber:
+		if len(schema.enum) > 0 && !slices.Contains(schema.enum, node.Value) {
+			fail("invalid value %q, expected one of %s", node.Value, strings.Join(schema.enum, ", "))
+		}
+	case schemaList:
+		for _, item := range node.Content {
+			// Structured items, like attributes, are scopes on their own
+			itemScope := scope
+			if schema.items.kind != schemaString {
+				itemScope = nodePosition(item)
+			}
+			checkNode(item, schema.items, key, itemScope, errs)
+		}
+	case schemaMap:
+		if schema.name != "" {
+			scope = nodePosition(node)
+		}
+		seen := make(map[string]bool, len(node.Content)/2)
+		for _, pair := range mappingPairs(node) {
+			keyNode, valueNode := pair[0], pair[1]
+			seen[keyNode.Value] = true
+
+			if schema.fields == nil {
+				checkNode(valueNode, schema.items, keyNode.Value, scope, errs)
+				continue
+			}
+			fieldSchema, ok := schema.fields[keyNode.Value]
+			if !ok {
+				msg := fmt.Sprintf("unknown key %q in %s", keyNode.Value, schema.name)
+				if suggestion := closestKey(keyNode.Value, schema.fields); suggestion != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+				}
+				*errs = append(*errs, &collectionError{pos: nodePosition(keyNode), scope: scope, msg: msg})
+				continue
+			}
+			checkNode(valueNode, fieldSchema, keyNode.Value, scope, errs)
+		}
+		for _, required := range schema.required {
+			if !seen[required] {
+				*errs = append(*errs, &collectionError{
+					pos:   nodePosition(node),
+					scope: scope,
+					msg:   fmt.Sprintf("missing required key %q in %s", required, schema.name),
+				})
+			}
+		}
+	}
+}
+
+// mappingPairs returns the key and value nodes of a mapping, resolving the
+// merge keys (<<) as the decoder does: the keys of the mapping override the
+// merged ones, and the first merged mapping defining a key wins
+func mappingPairs(node *yaml.Node) [][2]*yaml.Node {
+	var pairs, merged [][2]*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		if keyNode.Tag != "!!merge" {
+			pairs = append(pairs, [2]*yaml.Node{keyNode, valueNode})
+			continue
+		}
+		sources := []*yaml.Node{valueNode}
+		if valueNode.Kind == yaml.SequenceNode {
+			sources = valueNode.Content
+		}
+		for _, source := range sources {
+			if source.Kind == yaml.AliasNode {
+				source = source.Alias
+			}
+			if source.Kind == yaml.MappingNode {
+				merged = append(merged, mappingPairs(source)...)
+			}
+		}
+	}
+
+	defined := make(map[string]bool, len(pairs)+len(merged))
+	for _, pair := range pairs {
+		defined[pair[0].Value] = true
+	}
+	for _, pair := range merged {
+		if !defined[pair[0].Value] {
+			defined[pair[0].Value] = true
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+func kindMatches(node *yaml.Node, kind schemaKind) bool {
+	switch kind {
+	case schemaString:
+		return node.Kind == yaml.ScalarNode && node.Tag == "!!str"
+	case schemaNumber:
+		return node.Kind == yaml.ScalarNode && (node.Tag == "!!int" || node.Tag == "!!float")
+	case schemaBool:
+		return node.Kind == yaml.ScalarNode && node.Tag == "!!bool"
+	case schemaList:
+		return node.Kind == yaml.SequenceNode
+	case schemaMap:
+		return node.Kind == yaml.MappingNode
+	}
+	return false
+}
+
+func kindName(kind schemaKind) string {
+	switch kind {
+	case schemaString:
+		return "a string"
+	case schemaNumber:
+		return "a number"
+	case schemaBool:
+		return "a boolean"
+	case schemaList:
+		return "a list"
+	case schemaMap:
+		return "a map"
+	}
+	return "a value"
+}
+
+func nodeKindName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.MappingNode:
+		return "a map"
+	case yaml.ScalarNode:
+		return fmt.Sprintf("%s %q", strings.TrimPrefix(node.Tag, "!!"), node.Value)
+	}
+	return "an unexpected value"
+}
+
+// closestKey returns the known key that is at most two edits away from key
+func closestKey(key string, fields map[string]*schemaNode) string {
+	const maxDistance = 2
+	best, bestDistance := "", maxDistance+1
+	for field := range fields {
+		if d := editDistance(key, field); d < bestDistance || (d == bestDistance && field < best) {
+			best, bestDistance = field, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -20,5 +20,6 @@ var (
 type Client interface {
 	Open(config *gojmx.JMXConfig) (*gojmx.Client, error)
 	Close() error
+	QueryMBeanNames(mBeanGlobPattern string) ([]string, error)
 	QueryMBeanAttributes(mBeanNamePattern string, mBeanAttributeName ...string) ([]*gojmx.AttributeResponse, error)
 }
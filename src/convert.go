@@ -0,0 +1,143 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// convertedCollection is a collection definition produced by converting the
+// configuration of another JMX tool. Unlike collectionDefinitionParser it is
+// meant to be written, so empty options are left out.
+type convertedCollection struct {
+	// Version is the collection format the converted file is written in
+	Version int               `yaml:"version"`
+	Collect []*convertedBlock `yaml:"collect"`
+}
+
+type convertedBlock struct {
+	Domain    string           `yaml:"domain"`
+	EventType string           `yaml:"event_type,omitempty"`
+	Beans     []*convertedBean `yaml:"beans"`
+}
+
+type convertedBean struct {
+	Query             string                  `yaml:"query"`
+	QueryRegex        string                  `yaml:"query_regex,omitempty"`
+	Include           map[string]string       `yaml:"include,omitempty"`
+	Exclude           []string                `yaml:"exclude_regex,omitempty"`
+	ExcludeAttributes []string                `yaml:"exclude_attributes,omitempty"`
+	Tags              map[string]string       `yaml:"tags,omitempty"`
+	KeyProperties     *convertedKeyProperties `yaml:"key_properties,omitempty"`
+	// Attributes is empty to collect every attribute
+	Attributes []*convertedAttribute `yaml:"attributes,omitempty"`
+}
+
+type convertedKeyProperties struct {
+	Rename map[string]string `yaml:"rename,omitempty"`
+}
+
+type convertedAttribute struct {
+	Attr       string  `yaml:"attr,omitempty"`
+	AttrRegex  string  `yaml:"attr_regex,omitempty"`
+	MetricName string  `yaml:"metric_name,omitempty"`
+	MetricType string  `yaml:"metric_type,omitempty"`
+	Scale      float64 `yaml:"scale,omitempty"`
+}
+
+// conversionWarning is a setting of the converted configuration that
+// couldn't be translated faithfully
+type conversionWarning struct {
+	// source locates the setting, like "rule 3"
+	source string
+	msg    string
+}
+
+func (w conversionWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.source, w.msg)
+}
+
+// addBean adds a converted bean to the block of its domain. A bean selecting the
+// same beans as one already in the block gets its attributes appended to it
+// instead, so the first attribute matching is used, as the converted tools do.
+// Beans collecting every attribute are never merged.
+func (c *convertedCollection) addBean(domain, eventType string, bean *convertedBean) {
+	var block *convertedBlock
+	for _, b := range c.Collect {
+		if b.Domain == domain && b.EventType == eventType {
+			block = b
+			break
+		}
+	}
+	if block == nil {
+		block = &convertedBlock{Domain: domain, EventType: eventType}
+		c.Collect = append(c.Collect, block)
+	}
+
+	selection := *bean
+	selection.Attributes = nil
+	for _, existing := range block.Beans {
+		existingSelection := *existing
+		existingSelection.Attributes = nil
+		if len(bean.Attributes) > 0 && len(existing.Attributes) > 0 && reflect.DeepEqual(existingSelection, selection) {
+			existing.Attributes = append(existing.Attributes, bean.Attributes...)
+			return
+		}
+	}
+	block.Beans = append(block.Beans, bean)
+}
+
+// marshal writes the converted collection as a collection file, starting with
+// a comment naming what it was converted from
+func (c *convertedCollection) marshal(convertedFrom string) ([]byte, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("---\n# Converted from %s\n\n", convertedFrom)
+	return append([]byte(header), data...), nil
+}
+
+// runConversion converts the configuration file of another JMX tool with convert, writing
+// the collection definition to stdout and the warnings to stderr. It returns the exit code.
+func runConversion(file string, convert func([]byte) (*convertedCollection, []conversionWarning, error), stdout, stderr io.Writer) int {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to read %s: %v\n", file, err)
+		return 1
+	}
+
+	collection, warnings, err := convert(data)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to convert %s: %v\n", file, err)
+		return 1
+	}
+
+	output, err := collection.marshal(file)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to write the converted collection: %v\n", err)
+		return 1
+	}
+
+	// The conversion should always produce a valid definition, but check it anyway
+	// so any problem is found now instead of when the file is used
+	if c, err := parseCollectionBytes(output, "converted collection"); err != nil {
+		warnings = append(warnings, conversionWarning{source: "converted collection", msg: err.Error()})
+	} else if _, err := parseCollectionDefinition(c); err != nil {
+		warnings = append(warnings, conversionWarning{source: "converted collection", msg: err.Error()})
+	}
+
+	stdout.Write(output) // nolint: errcheck
+	for _, warning := range warnings {
+		fmt.Fprintf(stderr, "warning: %s\n", warning)
+	}
+	return 0
+}
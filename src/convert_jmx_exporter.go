@@ -0,0 +1,566 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// jmxExporterConfig is the part of a Prometheus jmx_exporter configuration
+// that can be converted to a collection definition
+type jmxExporterConfig struct {
+	LowercaseOutputName       bool              `yaml:"lowercaseOutputName"`
+	LowercaseOutputLabelNames bool              `yaml:"lowercaseOutputLabelNames"`
+	WhitelistObjectNames      []string          `yaml:"whitelistObjectNames"`
+	BlacklistObjectNames      []string          `yaml:"blacklistObjectNames"`
+	IncludeObjectNames        []string          `yaml:"includeObjectNames"`
+	ExcludeObjectNames        []string          `yaml:"excludeObjectNames"`
+	Rules                     []jmxExporterRule `yaml:"rules"`
+}
+
+type jmxExporterRule struct {
+	Pattern     string            `yaml:"pattern"`
+	Name        string            `yaml:"name"`
+	Type        string            `yaml:"type"`
+	Labels      map[string]string `yaml:"labels"`
+	ValueFactor float64           `yaml:"valueFactor"`
+	Value       interface{}       `yaml:"value"`
+}
+
+// jmxExporterTypes maps the jmx_exporter metric types to metric_type values
+var jmxExporterTypes = map[string]string{
+	"GAUGE":   "gauge",
+	"COUNTER": "rate",
+	"UNTYPED": "",
+}
+
+// anyValuePatterns are the patterns matching any key property value or attribute name
+var anyValuePatterns = map[string]bool{
+	"":      true,
+	".*":    true,
+	".+":    true,
+	"[^,]*": true,
+	"[^,]+": true,
+}
+
+// convertJmxExporter translates the rules of a jmx_exporter configuration into a
+// collection definition. Each rule pattern, like kafka.server<type=(.+), name=(.+)><>Count,
+// is split into the domain, the bean query and the attribute, and the capture groups
+// used in the rule name become metric_name placeholders. Anything that can't be
+// translated faithfully is returned as a warning.
+func convertJmxExporter(data []byte) (*convertedCollection, []conversionWarning, error) {
+	var config jmxExporterConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("invalid jmx_exporter configuration: %w", err)
+	}
+
+	var warnings []conversionWarning
+	warn := func(source, format string, a ...interface{}) {
+		warnings = append(warnings, conversionWarning{source: source, msg: fmt.Sprintf(format, a...)})
+	}
+
+	if config.LowercaseOutputName || config.LowercaseOutputLabelNames {
+		warn("configuration", "lowercaseOutputName and lowercaseOutputLabelNames are not supported, names are kept as written")
+	}
+	if len(config.WhitelistObjectNames)+len(config.BlacklistObjectNames)+len(config.IncludeObjectNames)+len(config.ExcludeObjectNames) > 0 {
+		warn("configuration", "object name filters are not converted, only the rule patterns select beans")
+	}
+
+	collection := &convertedCollection{Version: currentCollectionVersion}
+	for i, rule := range config.Rules {
+		source := fmt.Sprintf("rule %d", i+1)
+		ruleWarn := func(format string, a ...interface{}) { warn(source, format, a...) }
+
+		domain, bean, ok := convertJmxExporterRule(rule, ruleWarn)
+		if !ok {
+			continue
+		}
+		eventType := ""
+		if strings.ContainsAny(domain, "*?") {
+			eventType = "JMXSample"
+		}
+		collection.addBean(domain, eventType, bean)
+	}
+	return collection, warnings, nil
+}
+
+// exporterGroup is a capture group of a rule pattern, and the part of the ObjectName it captures
+type exporterGroup struct {
+	index      int
+	name       string
+	start, end int
+	// section is "domain", "key", "path", "attr" or "value"
+	section string
+	// key is the key property the group is part of, in the key section
+	key string
+	// whole tells whether the group captures the whole key property value or attribute name
+	whole bool
+}
+
+// convertJmxExporterRule converts one rule into a bean definition of its domain,
+// reporting with warn anything that isn't faithfully translated
+func convertJmxExporterRule(rule jmxExporterRule, warn func(format string, a ...interface{})) (string, *convertedBean, bool) {
+	if rule.Pattern == "" {
+		warn("rules without a pattern match every attribute of every bean and are not converted")
+		return "", nil, false
+	}
+	if rule.Value != nil {
+		warn("static values are not supported, the attribute value is reported instead")
+	}
+
+	p := rule.Pattern
+	groups, err := scanPatternGroups(p)
+	if err != nil {
+		warn("invalid pattern %q: %v", p, err)
+		return "", nil, false
+	}
+
+	// Split domain<bean properties><composite path>attribute: value
+	delims := topLevelIndexes(p, 0, len(p), "<>")
+	if len(delims) < 2 || p[delims[0]] != '<' || p[delims[1]] != '>' {
+		warn("pattern %q doesn't select beans with domain<key=value, ...>, it is not converted", p)
+		return "", nil, false
+	}
+	beanStart, beanEnd := delims[0]+1, delims[1]
+	attrStart, pathStart, pathEnd := beanEnd+1, -1, -1
+	if len(delims) >= 4 && delims[2] == beanEnd+1 && p[delims[2]] == '<' && p[delims[3]] == '>' {
+		pathStart, pathEnd = delims[2]+1, delims[3]
+		attrStart = pathEnd + 1
+	}
+	attrEnd := len(p)
+	if colons := topLevelIndexes(p, attrStart, len(p), ":"); len(colons) > 0 {
+		attrEnd = colons[0]
+		if valuePattern := strings.TrimSpace(p[attrEnd+1:]); !anyValuePatterns[strings.Trim(valuePattern, "()")] {
+			warn("the value pattern %q is ignored, values are not filtered", valuePattern)
+		}
+	}
+
+	locate := func(g *exporterGroup) {
+		switch {
+		case g.start < beanStart:
+			g.section = "domain"
+		case g.start < beanEnd:
+			g.section = "key"
+		case pathStart != -1 && g.start < pathEnd:
+			g.section = "path"
+		case g.start < attrEnd:
+			g.section = "attr"
+			g.whole = pathStart == -1 && g.start == attrStart && g.end == attrEnd
+		default:
+			g.section = "value"
+		}
+	}
+	for i := range groups {
+		locate(&groups[i])
+	}
+
+	// Domain
+	domain, exact := jmxWildcard(p[:beanStart-1])
+	if !exact {
+		warn("domain pattern %q is converted to %q, which may match more domains", p[:beanStart-1], domain)
+	}
+
+	// Bean key properties
+	bean := &convertedBean{}
+	var queryParts []string
+	wildcardRest := false
+	for _, piece := range splitTopLevel(p, beanStart, beanEnd, ',') {
+		text := strings.TrimSpace(p[piece[0]:piece[1]])
+		start := piece[0] + strings.Index(p[piece[0]:piece[1]], text)
+		eq := strings.IndexByte(text, '=')
+		if eq == -1 || !isLiteralPattern(text[:eq]) {
+			if !anyValuePatterns[text] {
+				warn("bean pattern %q can't be translated to key properties, any other key property is accepted", text)
+			}
+			wildcardRest = true
+			continue
+		}
+		key, value := unescapePattern(text[:eq]), text[eq+1:]
+		valueStart, valueEnd := start+eq+1, start+len(text)
+		for i := range groups {
+			g := &groups[i]
+			if g.section == "key" && g.start >= valueStart && g.end <= valueEnd {
+				g.key = key
+				g.whole = g.start == valueStart && g.end == valueEnd
+			}
+		}
+
+		if isLiteralPattern(value) {
+			queryParts = append(queryParts, key+"="+unescapePattern(value))
+			continue
+		}
+		queryParts = append(queryParts, key+"=*")
+		inner := value
+		if g := groupAt(groups, valueStart, valueEnd); g != nil {
+			inner = p[g.start+1+groupHeaderLength(p[g.start:]) : g.end-1]
+		}
+		if anyValuePatterns[inner] {
+			continue
+		}
+		includePattern := "^(?:" + inner + ")$"
+		if _, err := regexp.Compile(includePattern); err != nil {
+			warn("key property pattern %q can't be used in Go, any value of %s is accepted", value, key)
+			continue
+		}
+		if bean.Include == nil {
+			bean.Include = make(map[string]string)
+		}
+		bean.Include[key] = includePattern
+	}
+	if wildcardRest || len(queryParts) == 0 {
+		queryParts = append(queryParts, "*")
+	}
+	bean.Query = strings.Join(queryParts, ",")
+
+	// Attribute, with its composite path. nrjmx capitalizes composite keys.
+	attribute := &convertedAttribute{}
+	var path []string
+	if pathStart != -1 && pathStart < pathEnd {
+		for _, piece := range splitTopLevel(p, pathStart, pathEnd, ',') {
+			path = append(path, strings.TrimSpace(p[piece[0]:piece[1]]))
+		}
+	}
+	attrPattern := strings.TrimSpace(p[attrStart:attrEnd])
+	parts := append(path, attrPattern)
+	literal := true
+	for _, part := range parts {
+		literal = literal && isLiteralPattern(part) && part != ""
+	}
+	if literal {
+		names := make([]string, len(parts))
+		for i, part := range parts {
+			names[i] = unescapePattern(part)
+			if i > 0 {
+				names[i] = capitalize(names[i])
+			}
+		}
+		attribute.Attr = strings.Join(names, ".")
+	} else {
+		regexParts := make([]string, len(parts))
+		for i, part := range parts {
+			if part == "" {
+				part = ".*"
+			}
+			if i > 0 {
+				part = "(?i:" + part + ")"
+			}
+			regexParts[i] = part
+		}
+		attribute.AttrRegex = strings.Join(regexParts, `\.`)
+		if _, err := createAttributeRegex(attribute.AttrRegex, false); err != nil {
+			warn("attribute pattern %q can't be used in Go: %v", attribute.AttrRegex, err)
+			return "", nil, false
+		}
+	}
+
+	// Metric name, type and value factor
+	attribute.MetricName = convertJmxExporterName(rule.Name, groups, warn)
+	metricType, known := jmxExporterTypes[strings.ToUpper(rule.Type)]
+	if !known && rule.Type != "" {
+		warn("type %s is not supported, the metric type is inferred", rule.Type)
+	}
+	attribute.MetricType = metricType
+	if rule.ValueFactor != 0 && rule.ValueFactor != 1 {
+		attribute.Scale = rule.ValueFactor
+	}
+	bean.Attributes = []*convertedAttribute{attribute}
+
+	// Labels become tags or renamed key properties
+	labels := make([]string, 0, len(rule.Labels))
+	for label := range rule.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		value := rule.Labels[label]
+		refs := exporterRefs.FindAllStringSubmatch(value, -1)
+		if len(refs) == 0 {
+			if bean.Tags == nil {
+				bean.Tags = make(map[string]string)
+			}
+			bean.Tags[label] = value
+			continue
+		}
+		g := findExporterGroup(groups, refs[0][1]+refs[0][2])
+		if len(refs) > 1 || refs[0][0] != value || g == nil || g.section != "key" {
+			warn("label %s=%q can't be translated, only labels set to a key property are", label, value)
+			continue
+		}
+		if !g.whole {
+			warn("label %s is set to the whole value of the %s key property", label, g.key)
+		}
+		if bean.KeyProperties == nil {
+			bean.KeyProperties = &convertedKeyProperties{Rename: make(map[string]string)}
+		}
+		if previous, ok := bean.KeyProperties.Rename[g.key]; ok && previous != label {
+			warn("key property %s is already reported as %s, label %s is dropped", g.key, previous, label)
+			continue
+		}
+		bean.KeyProperties.Rename[g.key] = label
+	}
+	return domain, bean, true
+}
+
+// exporterRefs matches the $1, ${1} and ${name} capture group references of jmx_exporter
+var exporterRefs = regexp.MustCompile(`\$(?:(\d+)|\{(\w+)\})`)
+
+// convertJmxExporterName turns a rule name into a metric_name template, replacing
+// capture group references with the key property or attribute name they capture
+func convertJmxExporterName(name string, groups []exporterGroup, warn func(format string, a ...interface{})) string {
+	if strings.ContainsAny(name, "{}") {
+		warn("name %q contains braces, which are template placeholders in metric_name", name)
+	}
+	return exporterRefs.ReplaceAllStringFunc(name, func(ref string) string {
+		m := exporterRefs.FindStringSubmatch(ref)
+		g := findExporterGroup(groups, m[1]+m[2])
+		switch {
+		case g == nil:
+			warn("name %q references the unknown group %s", name, ref)
+			return ""
+		case g.section == "key":
+			if !g.whole {
+				warn("name %q uses the whole value of the %s key property for %s", name, g.key, ref)
+			}
+			return "{" + g.key + "}"
+		case g.section == "attr":
+			if !g.whole {
+				warn("name %q uses the whole attribute name for %s", name, ref)
+			}
+			return "{attr}"
+		}
+		warn("name %q references %s in the %s, which can't be resolved, it is left out", name, ref, g.section)
+		return ""
+	})
+}
+
+// findExporterGroup finds a capture group by its number or its name
+func findExporterGroup(groups []exporterGroup, ref string) *exporterGroup {
+	index, err := strconv.Atoi(ref)
+	for i := range groups {
+		if (err == nil && groups[i].index == index) || (err != nil && groups[i].name == ref) {
+			return &groups[i]
+		}
+	}
+	return nil
+}
+
+// groupAt returns the capture group spanning exactly from start to end
+func groupAt(groups []exporterGroup, start, end int) *exporterGroup {
+	for i := range groups {
+		if groups[i].start == start && groups[i].end == end {
+			return &groups[i]
+		}
+	}
+	return nil
+}
+
+// scanPatternGroups finds the capture groups of a Java regex, numbered as Java does
+func scanPatternGroups(p string) ([]exporterGroup, error) {
+	var groups []exporterGroup
+	var open []int // indexes in groups of the open capture groups, -1 for other groups
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			i++
+		case '[':
+			i = skipCharClass(p, i)
+		case '(':
+			header := groupHeaderLength(p[i:])
+			if header == 0 && strings.HasPrefix(p[i:], "(?") {
+				open = append(open, -1)
+				continue
+			}
+			g := exporterGroup{index: len(groups) + 1, start: i}
+			if header > 0 {
+				g.name = p[i+1+strings.IndexByte(p[i:], '<') : i+header]
+			}
+			groups = append(groups, g)
+			open = append(open, len(groups)-1)
+		case ')':
+			if len(open) == 0 {
+				return nil, fmt.Errorf("unexpected ')'")
+			}
+			if last := open[len(open)-1]; last != -1 {
+				groups[last].end = i + 1
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	if len(open) > 0 {
+		return nil, fmt.Errorf("missing ')'")
+	}
+	return groups, nil
+}
+
+// groupHeaderLength returns the length of the (?<name> or (?P<name> header of a named
+// capture group, without its opening parenthesis. It's 0 for any other group.
+func groupHeaderLength(group string) int {
+	for _, prefix := range []string{"(?<", "(?P<"} {
+		if strings.HasPrefix(group, prefix) && len(group) > len(prefix) && group[len(prefix)] != '=' && group[len(prefix)] != '!' {
+			if end := strings.IndexByte(group, '>'); end != -1 {
+				return end
+			}
+		}
+	}
+	return 0
+}
+
+// skipCharClass returns the index of the ']' closing the character class opened at i
+func skipCharClass(p string, i int) int {
+	j := i + 1
+	if j < len(p) && p[j] == '^' {
+		j++
+	}
+	if j < len(p) && p[j] == ']' {
+		j++
+	}
+	for ; j < len(p); j++ {
+		switch p[j] {
+		case '\\':
+			j++
+		case ']':
+			return j
+		}
+	}
+	return len(p)
+}
+
+// topLevelIndexes returns the indexes between from and to of the given characters
+// that are not escaped, nor inside a group or a character class
+func topLevelIndexes(p string, from, to int, chars string) []int {
+	var indexes []int
+	depth := 0
+	for i := from; i < to; i++ {
+		switch c := p[i]; {
+		case c == '\\':
+			i++
+		case c == '[':
+			i = skipCharClass(p, i)
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && strings.IndexByte(chars, c) != -1:
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// splitTopLevel splits the pattern between from and to on the top level separators,
+// returning the start and end of each piece
+func splitTopLevel(p string, from, to int, sep byte) [][2]int {
+	var pieces [][2]int
+	start := from
+	for _, i := range topLevelIndexes(p, from, to, string(sep)) {
+		pieces = append(pieces, [2]int{start, i})
+		start = i + 1
+	}
+	return append(pieces, [2]int{start, to})
+}
+
+// isLiteralPattern tells whether the pattern only matches itself. Unescaped dots
+// are taken as literal dots, as they are almost always meant in ObjectNames.
+func isLiteralPattern(p string) bool {
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			if i+1 < len(p) && unicode.IsLetter(rune(p[i+1])) {
+				return false
+			}
+			i++
+		case '^', '$', '*', '+', '?', '(', ')', '[', ']', '{', '}', '|':
+			return false
+		}
+	}
+	return true
+}
+
+// unescapePattern removes the escaping of a literal pattern
+func unescapePattern(p string) string {
+	sb := strings.Builder{}
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+1 < len(p) {
+			i++
+		}
+		sb.WriteByte(p[i])
+	}
+	return sb.String()
+}
+
+// jmxWildcard converts a domain pattern to a JMX domain pattern, replacing every part
+// that isn't literal with a '*'. It tells whether the conversion is exact.
+func jmxWildcard(p string) (string, bool) {
+	if isLiteralPattern(p) {
+		return unescapePattern(p), true
+	}
+	sb := strings.Builder{}
+	exact := true
+	wildcard := func(matchesAnything bool) {
+		exact = exact && matchesAnything
+		if !strings.HasSuffix(sb.String(), "*") {
+			sb.WriteByte('*')
+		}
+	}
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '\\':
+			if i+1 < len(p) && unicode.IsLetter(rune(p[i+1])) {
+				wildcard(false)
+			} else if i+1 < len(p) {
+				sb.WriteByte(p[i+1])
+			}
+			i++
+		case '[':
+			i = skipCharClass(p, i)
+			wildcard(false)
+		case '(':
+			depth := 0
+			start := i
+			for ; i < len(p); i++ {
+				if p[i] == '\\' {
+					i++
+				} else if p[i] == '(' {
+					depth++
+				} else if p[i] == ')' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			inner := p[start+1 : min(i, len(p))]
+			wildcard(anyValuePatterns[inner])
+		case '*', '+', '?':
+			// Only .* is converted exactly, any other quantified character
+			// is replaced with the wildcard along with the quantifier
+			anything := c == '*' && i > 0 && p[i-1] == '.' && (i < 2 || p[i-2] != '\\')
+			if s := sb.String(); s != "" && !strings.HasSuffix(s, "*") {
+				sb.Reset()
+				sb.WriteString(s[:len(s)-1])
+			}
+			wildcard(anything)
+		case '^', '$':
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), exact
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertJmxExporter(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "test", "data", "jmx-exporter-kafka.yml"))
+	assert.NoError(t, err)
+
+	collection, warnings, err := convertJmxExporter(data)
+	assert.NoError(t, err)
+
+	expected := &convertedCollection{
+		Version: currentCollectionVersion,
+		Collect: []*convertedBlock{
+			{
+				Domain: "kafka.server",
+				Beans: []*convertedBean{
+					{
+						Query:   "type=*,name=*",
+						Include: map[string]string{"name": `^(?:(.+)PerSec\w*)$`},
+						Attributes: []*convertedAttribute{
+							{Attr: "Count", MetricName: "kafka_server_{type}_{name}_total", MetricType: "rate"},
+						},
+					},
+					{
+						Query:         "type=*,name=*,topic=*",
+						Tags:          map[string]string{"cluster": "main"},
+						KeyProperties: &convertedKeyProperties{Rename: map[string]string{"topic": "topic"}},
+						Attributes: []*convertedAttribute{
+							{Attr: "OneMinuteRate", MetricName: "kafka_server_{type}_{name}", MetricType: "gauge"},
+						},
+					},
+				},
+			},
+			{
+				Domain: "kafka.network",
+				Beans: []*convertedBean{
+					{
+						Query:   "type=RequestMetrics,name=RequestsPerSec,request=*",
+						Include: map[string]string{"request": "^(?:Produce|Fetch)$"},
+						Attributes: []*convertedAttribute{
+							{Attr: "Count", MetricName: "kafka_network_requests_total", MetricType: "rate"},
+						},
+					},
+				},
+			},
+			{
+				Domain: "java.lang",
+				Beans: []*convertedBean{
+					{
+						Query: "type=Memory",
+						Attributes: []*convertedAttribute{
+							{Attr: "HeapMemoryUsage.Used", MetricName: "jvm_heap_used_bytes", Scale: 0.001},
+						},
+					},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, collection)
+
+	var messages []string
+	for _, warning := range warnings {
+		messages = append(messages, warning.String())
+	}
+	assert.Equal(t, []string{
+		"configuration: lowercaseOutputName and lowercaseOutputLabelNames are not supported, names are kept as written",
+		`rule 1: name "kafka_server_$1_$2_total" uses the whole value of the name key property for $2`,
+		`rule 5: pattern ".*" doesn't select beans with domain<key=value, ...>, it is not converted`,
+	}, messages)
+}
+
+func TestConvertJmxExporter_Warnings(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rule    string
+		warning string
+	}{
+		{
+			"value pattern",
+			"pattern: 'java.lang<type=Memory><>ObjectPendingFinalizationCount: ([1-9]\\d*)'",
+			`rule 1: the value pattern "([1-9]\\d*)" is ignored, values are not filtered`,
+		},
+		{
+			"static value",
+			"{pattern: 'java.lang<type=Runtime><>Uptime', value: 1}",
+			"rule 1: static values are not supported, the attribute value is reported instead",
+		},
+		{
+			"unsupported type",
+			"{pattern: 'java.lang<type=Runtime><>Uptime', type: HISTOGRAM}",
+			"rule 1: type HISTOGRAM is not supported, the metric type is inferred",
+		},
+		{
+			"computed label",
+			"{pattern: 'java.lang<type=(.+)><>Uptime', labels: {kind: 'jvm_$1'}}",
+			`rule 1: label kind="jvm_$1" can't be translated, only labels set to a key property are`,
+		},
+		{
+			"domain pattern",
+			"{pattern: 'kafka.(\\w+)<type=(.+)><>Value', name: 'kafka_$1_$2'}",
+			`rule 1: domain pattern "kafka.(\\w+)" is converted to "kafka.*", which may match more domains`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, warnings, err := convertJmxExporter([]byte("rules:\n  - " + tc.rule + "\n"))
+			assert.NoError(t, err)
+			var messages []string
+			for _, warning := range warnings {
+				messages = append(messages, warning.String())
+			}
+			assert.Contains(t, messages, tc.warning)
+		})
+	}
+}
+
+func TestConvertJmxExporter_AttributeRegex(t *testing.T) {
+	collection, _, err := convertJmxExporter([]byte(`rules:
+  - pattern: 'kafka.(\w+)<type=(.+), name=(.+)><>(Count|Value)'
+    name: kafka_$1_$2_$3_$4
+`))
+	assert.NoError(t, err)
+	assert.Len(t, collection.Collect, 1)
+
+	block := collection.Collect[0]
+	assert.Equal(t, "kafka.*", block.Domain)
+	assert.Equal(t, "JMXSample", block.EventType)
+	assert.Equal(t, "type=*,name=*", block.Beans[0].Query)
+	assert.Equal(t, &convertedAttribute{AttrRegex: "(Count|Value)", MetricName: "kafka__{type}_{name}_{attr}"}, block.Beans[0].Attributes[0])
+}
+
+func TestRunConversion(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := runConversion(filepath.Join("..", "test", "data", "jmx-exporter-kafka.yml"), convertJmxExporter, stdout, stderr)
+	assert.Equal(t, 0, code)
+
+	c, err := parseCollectionBytes(stdout.Bytes(), "converted")
+	assert.NoError(t, err)
+	assert.Equal(t, currentCollectionVersion, c.Version)
+	domains, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+	assert.Len(t, domains, 3)
+
+	assert.True(t, strings.HasPrefix(stdout.String(), "---\n# Converted from "))
+	assert.Equal(t, 3, strings.Count(stderr.String(), "warning: "))
+	assert.NotContains(t, stderr.String(), "converted collection")
+
+	code = runConversion("missing.yml", convertJmxExporter, stdout, stderr)
+	assert.Equal(t, 1, code)
+}
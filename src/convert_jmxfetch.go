@@ -0,0 +1,473 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// jmxFetchConfig is the part of a Datadog JMXFetch configuration that can be converted
+// to a collection definition. The conf lists of init_config and of the instances, and
+// the jmx_metrics of the metrics.yaml files, all have the same format.
+type jmxFetchConfig struct {
+	InitConfig struct {
+		Conf                  []jmxFetchConf `yaml:"conf"`
+		CollectDefaultMetrics bool           `yaml:"collect_default_metrics"`
+	} `yaml:"init_config"`
+	Instances []struct {
+		Conf []jmxFetchConf `yaml:"conf"`
+		Tags interface{}    `yaml:"tags"`
+	} `yaml:"instances"`
+	JmxMetrics []jmxFetchConf `yaml:"jmx_metrics"`
+}
+
+type jmxFetchConf struct {
+	Include map[string]interface{} `yaml:"include"`
+	Exclude map[string]interface{} `yaml:"exclude"`
+}
+
+// jmxFetchTypes maps the JMXFetch metric types to metric_type values
+var jmxFetchTypes = map[string]string{
+	"gauge":           "gauge",
+	"counter":         "rate",
+	"rate":            "rate",
+	"monotonic_count": "delta",
+}
+
+// jmxFetchFilterOptions are the include and exclude options that aren't key property filters
+var jmxFetchFilterOptions = map[string]bool{
+	"domain":       true,
+	"domain_regex": true,
+	"bean":         true,
+	"bean_name":    true,
+	"bean_regex":   true,
+	"attribute":    true,
+	"tags":         true,
+}
+
+// jmxFetchAliasRefs matches the $domain, $attribute and $<key property> references of JMXFetch aliases
+var jmxFetchAliasRefs = regexp.MustCompile(`\$([A-Za-z_]\w*)`)
+
+// convertJmxFetch translates the include and exclude filters of a JMXFetch configuration
+// into a collection definition. Each include filter becomes a bean definition, with the
+// attribute aliases as metric_name and the exclude filter as exclude_regex and
+// exclude_attributes patterns.
+// Anything that can't be translated faithfully is returned as a warning.
+func convertJmxFetch(data []byte) (*convertedCollection, []conversionWarning, error) {
+	var config jmxFetchConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("invalid JMXFetch configuration: %w", err)
+	}
+
+	var warnings []conversionWarning
+	warn := func(source, format string, a ...interface{}) {
+		warnings = append(warnings, conversionWarning{source: source, msg: fmt.Sprintf(format, a...)})
+	}
+
+	if config.InitConfig.CollectDefaultMetrics {
+		warn("init_config", "collect_default_metrics is not converted, use COLLECTION_PRESETS=jvm for the JVM metrics")
+	}
+
+	collection := &convertedCollection{Version: currentCollectionVersion}
+	convertConfs := func(section string, confs []jmxFetchConf) {
+		for i, conf := range confs {
+			source := fmt.Sprintf("%s %d", section, i+1)
+			confWarn := func(format string, a ...interface{}) { warn(source, format, a...) }
+			for _, target := range convertJmxFetchConf(conf, confWarn) {
+				eventType := ""
+				if strings.ContainsAny(target.domain, "*?") {
+					eventType = "JMXSample"
+				}
+				collection.addBean(target.domain, eventType, target.bean)
+			}
+		}
+	}
+	convertConfs("init_config conf", config.InitConfig.Conf)
+	for i, instance := range config.Instances {
+		if instance.Tags != nil {
+			warn(fmt.Sprintf("instance %d", i+1), "instance tags are not converted, set them with CUSTOM_ATTRIBUTES")
+		}
+		convertConfs(fmt.Sprintf("instance %d conf", i+1), instance.Conf)
+	}
+	convertConfs("jmx_metrics", config.JmxMetrics)
+
+	return collection, warnings, nil
+}
+
+// jmxFetchTarget is a converted bean along with the domain it is collected from
+type jmxFetchTarget struct {
+	domain string
+	bean   *convertedBean
+}
+
+// convertJmxFetchConf converts a conf entry into the beans its include filter selects,
+// reporting with warn anything that isn't faithfully translated
+func convertJmxFetchConf(conf jmxFetchConf, warn func(format string, a ...interface{})) []jmxFetchTarget {
+	include := conf.Include
+	if include == nil {
+		warn("entries without an include filter are not converted")
+		return nil
+	}
+	for _, option := range []string{"class", "class_regex", "exclude_tags"} {
+		if _, ok := include[option]; ok {
+			warn("include option %s is not supported, it is ignored", option)
+			delete(include, option)
+		}
+	}
+
+	domains := jmxFetchStrings(include["domain"], "domain", warn)
+	beanNames := append(jmxFetchStrings(include["bean"], "bean", warn), jmxFetchStrings(include["bean_name"], "bean_name", warn)...)
+
+	// $domain in aliases can only be resolved when the filter selects a single domain
+	aliasDomains := append([]string{}, domains...)
+	for _, name := range beanNames {
+		aliasDomains = append(aliasDomains, strings.SplitN(name, ":", 2)[0])
+	}
+	aliasDomain := ""
+	if len(aliasDomains) > 0 && allEqual(aliasDomains) {
+		aliasDomain = aliasDomains[0]
+	}
+
+	// The bean settings shared by every target
+	shared := &convertedBean{Attributes: convertJmxFetchAttributes(include["attribute"], aliasDomain, warn)}
+	shared.Exclude, shared.ExcludeAttributes = convertJmxFetchExclude(conf.Exclude, warn)
+	convertJmxFetchTags(include["tags"], shared, warn)
+	template := func() *convertedBean {
+		bean := *shared
+		bean.Attributes = append([]*convertedAttribute(nil), shared.Attributes...)
+		return &bean
+	}
+
+	var targets []jmxFetchTarget
+	// bean lists exact bean names, with their domain
+	for _, name := range beanNames {
+		domain, properties, found := strings.Cut(name, ":")
+		if !found {
+			warn("bean %q is not a bean name, it is not converted", name)
+			continue
+		}
+		bean := template()
+		bean.Query = properties
+		targets = append(targets, jmxFetchTarget{domain: domain, bean: bean})
+	}
+	if len(targets) > 0 {
+		return targets
+	}
+
+	// Otherwise the key property filters select the beans of each domain
+	query, includePatterns := convertJmxFetchKeyFilters(include, warn)
+	if len(domains) == 0 {
+		domains = []string{"*"}
+		if domainRegex := jmxFetchStrings(include["domain_regex"], "domain_regex", warn); len(domainRegex) > 0 {
+			domains = nil
+			for _, pattern := range domainRegex {
+				domain, exact := jmxWildcard(pattern)
+				if !exact {
+					warn("domain_regex %q is converted to the domain %q, which may match more domains", pattern, domain)
+				}
+				domains = append(domains, domain)
+			}
+		}
+	}
+
+	beanRegexes := jmxFetchStrings(include["bean_regex"], "bean_regex", warn)
+	for _, domain := range domains {
+		if len(beanRegexes) == 0 {
+			bean := template()
+			bean.Query, bean.Include = query, includePatterns
+			targets = append(targets, jmxFetchTarget{domain: domain, bean: bean})
+			continue
+		}
+		for _, pattern := range beanRegexes {
+			queryRegex := "^(?:" + pattern + ")$"
+			if _, err := regexp.Compile(queryRegex); err != nil {
+				warn("bean_regex %q can't be used in Go, it is not converted", pattern)
+				continue
+			}
+			// query_regex selects the beans exactly, the domain only narrows the query
+			beanDomain := domain
+			if beanDomain == "*" {
+				beanDomain = jmxFetchRegexDomain(pattern)
+			}
+			bean := template()
+			bean.Query, bean.Include, bean.QueryRegex = query, includePatterns, queryRegex
+			targets = append(targets, jmxFetchTarget{domain: beanDomain, bean: bean})
+		}
+	}
+	return targets
+}
+
+// jmxFetchRegexDomain returns the domain pattern of the beans a bean_regex matches
+func jmxFetchRegexDomain(pattern string) string {
+	colons := topLevelIndexes(pattern, 0, len(pattern), ":")
+	if len(colons) == 0 {
+		return "*"
+	}
+	domain, _ := jmxWildcard(pattern[:colons[0]])
+	if domain == "" {
+		return "*"
+	}
+	return domain
+}
+
+// convertJmxFetchKeyFilters converts the key property filters of an include filter,
+// returning the bean query and the include patterns of the values it can't express
+func convertJmxFetchKeyFilters(include map[string]interface{}, warn func(format string, a ...interface{})) (string, map[string]string) {
+	keys := make([]string, 0, len(include))
+	for key := range include {
+		if !jmxFetchFilterOptions[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var queryParts []string
+	var includePatterns map[string]string
+	for _, key := range keys {
+		values := jmxFetchStrings(include[key], key, warn)
+		if len(values) == 1 && !strings.ContainsAny(values[0], `,=:*?"`) {
+			queryParts = append(queryParts, key+"="+values[0])
+			continue
+		}
+		if len(values) == 0 {
+			continue
+		}
+		queryParts = append(queryParts, key+"=*")
+		if includePatterns == nil {
+			includePatterns = make(map[string]string)
+		}
+		includePatterns[key] = "^" + quoteAlternatives(values) + "$"
+	}
+	// The key property filters don't need to list every key property
+	queryParts = append(queryParts, "*")
+	return strings.Join(queryParts, ","), includePatterns
+}
+
+// convertJmxFetchAttributes converts the attribute list or map of an include filter
+func convertJmxFetchAttributes(raw interface{}, domain string, warn func(format string, a ...interface{})) []*convertedAttribute {
+	var attributes []*convertedAttribute
+	switch a := raw.(type) {
+	case nil:
+	case map[string]interface{}:
+		names := make([]string, 0, len(a))
+		for name := range a {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			attribute := &convertedAttribute{Attr: jmxFetchAttributeName(name)}
+			options, ok := a[name].(map[string]interface{})
+			if !ok && a[name] != nil {
+				warn("attribute %s must be a map of alias and metric_type, its settings are ignored", name)
+			}
+			for option, value := range options {
+				s := fmt.Sprint(value)
+				switch option {
+				case "alias":
+					attribute.MetricName = convertJmxFetchAlias(s, domain, warn)
+				case "metric_type":
+					metricType, known := jmxFetchTypes[strings.ToLower(s)]
+					if !known {
+						warn("metric_type %s of attribute %s is not supported, the metric type is inferred", s, name)
+					}
+					attribute.MetricType = metricType
+				default:
+					warn("attribute option %s of attribute %s is not supported, it is ignored", option, name)
+				}
+			}
+			attributes = append(attributes, attribute)
+		}
+	default:
+		for _, name := range jmxFetchStrings(raw, "attribute", warn) {
+			attributes = append(attributes, &convertedAttribute{Attr: jmxFetchAttributeName(name)})
+		}
+	}
+	return attributes
+}
+
+// jmxFetchAttributeName converts an attribute name, capitalizing the composite
+// data keys, like nrjmx does
+func jmxFetchAttributeName(name string) string {
+	parts := strings.Split(name, ".")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = capitalize(parts[i])
+	}
+	return strings.Join(parts, ".")
+}
+
+// convertJmxFetchAlias turns an alias into a metric_name template. $attribute is the
+// attribute name, $domain the domain, and any other reference a key property.
+func convertJmxFetchAlias(alias string, domain string, warn func(format string, a ...interface{})) string {
+	if strings.ContainsAny(alias, "{}") {
+		warn("alias %q contains braces, which are template placeholders in metric_name", alias)
+	}
+	return jmxFetchAliasRefs.ReplaceAllStringFunc(alias, func(ref string) string {
+		switch name := ref[1:]; name {
+		case "attribute":
+			return "{attr}"
+		case "domain":
+			if domain == "" || strings.ContainsAny(domain, "*?") {
+				warn("alias %q references $domain, which can't be resolved without a single domain, it is left out", alias)
+				return ""
+			}
+			return domain
+		case "attr":
+			return "{key:attr}"
+		default:
+			return "{" + name + "}"
+		}
+	})
+}
+
+// convertJmxFetchTags converts the tags of an include filter. Static tags become
+// bean tags, and tags set to a key property rename it.
+func convertJmxFetchTags(raw interface{}, bean *convertedBean, warn func(format string, a ...interface{})) {
+	tags, ok := raw.(map[string]interface{})
+	if !ok {
+		if raw != nil {
+			warn("tags must be a map, they are not converted")
+		}
+		return
+	}
+
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := fmt.Sprint(tags[name])
+		if !strings.Contains(value, "$") {
+			if bean.Tags == nil {
+				bean.Tags = make(map[string]string)
+			}
+			bean.Tags[name] = value
+			continue
+		}
+		ref := jmxFetchAliasRefs.FindStringSubmatch(value)
+		if ref == nil || ref[0] != value || ref[1] == "attribute" || ref[1] == "domain" {
+			warn("tag %s=%q can't be translated, only tags set to a key property are", name, value)
+			continue
+		}
+		if bean.KeyProperties == nil {
+			bean.KeyProperties = &convertedKeyProperties{Rename: make(map[string]string)}
+		}
+		if previous, ok := bean.KeyProperties.Rename[ref[1]]; ok && previous != name {
+			warn("key property %s is already reported as %s, tag %s is dropped", ref[1], previous, name)
+			continue
+		}
+		bean.KeyProperties.Rename[ref[1]] = name
+	}
+}
+
+// convertJmxFetchExclude converts an exclude filter to exclude_regex patterns, matched
+// against the domain:bean ObjectName, and exclude_attributes patterns, matched against
+// the attribute name. JMXFetch excludes the attributes matching any of its options, so
+// each of them becomes its own pattern.
+func convertJmxFetchExclude(exclude map[string]interface{}, warn func(format string, a ...interface{})) (patterns, attributePatterns []string) {
+	addRegex := func(option, pattern, full string) {
+		if _, err := regexp.Compile(full); err != nil {
+			warn("exclude %s %q can't be used in Go, it is not converted", option, pattern)
+			return
+		}
+		patterns = append(patterns, full)
+	}
+
+	for _, domain := range jmxFetchStrings(exclude["domain"], "domain", warn) {
+		patterns = append(patterns, "^"+regexp.QuoteMeta(domain)+":")
+	}
+	for _, pattern := range jmxFetchStrings(exclude["domain_regex"], "domain_regex", warn) {
+		addRegex("domain_regex", pattern, "^(?:"+pattern+"):")
+	}
+	for _, option := range []string{"bean", "bean_name"} {
+		for _, name := range jmxFetchStrings(exclude[option], option, warn) {
+			patterns = append(patterns, "^"+regexp.QuoteMeta(name)+"$")
+		}
+	}
+	for _, pattern := range jmxFetchStrings(exclude["bean_regex"], "bean_regex", warn) {
+		addRegex("bean_regex", pattern, "^(?:"+pattern+")$")
+	}
+
+	keys := make([]string, 0, len(exclude))
+	for key := range exclude {
+		if !jmxFetchFilterOptions[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if values := jmxFetchStrings(exclude[key], key, warn); len(values) > 0 {
+			patterns = append(patterns, "[:,]"+regexp.QuoteMeta(key)+"="+quoteAlternatives(values)+"(?:,|$)")
+		}
+	}
+
+	var attributes []string
+	if a, ok := exclude["attribute"].(map[string]interface{}); ok {
+		for name := range a {
+			attributes = append(attributes, jmxFetchAttributeName(name))
+		}
+		sort.Strings(attributes)
+	} else {
+		for _, name := range jmxFetchStrings(exclude["attribute"], "attribute", warn) {
+			attributes = append(attributes, jmxFetchAttributeName(name))
+		}
+	}
+	if len(attributes) > 0 {
+		attributePatterns = append(attributePatterns, "^"+quoteAlternatives(attributes)+"$")
+	}
+
+	if _, ok := exclude["tags"]; ok {
+		warn("exclude tags are not supported, they are ignored")
+	}
+	return patterns, attributePatterns
+}
+
+// jmxFetchStrings returns the value of a filter option, which can be a single value or a list
+func jmxFetchStrings(raw interface{}, option string, warn func(format string, a ...interface{})) []string {
+	switch v := raw.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			switch e.(type) {
+			case []interface{}, map[string]interface{}:
+				warn("%s values must be strings, %v is ignored", option, e)
+			default:
+				values = append(values, fmt.Sprint(e))
+			}
+		}
+		return values
+	case map[string]interface{}:
+		warn("%s must be a value or a list, it is ignored", option)
+		return nil
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// quoteAlternatives returns a group matching any of the literal values
+func quoteAlternatives(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = regexp.QuoteMeta(value)
+	}
+	return "(?:" + strings.Join(quoted, "|") + ")"
+}
+
+func allEqual(values []string) bool {
+	for _, value := range values {
+		if value != values[0] {
+			return false
+		}
+	}
+	return true
+}
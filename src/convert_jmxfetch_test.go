@@ -0,0 +1,166 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertJmxFetch(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "test", "data", "jmxfetch-cassandra.yml"))
+	assert.NoError(t, err)
+
+	collection, warnings, err := convertJmxFetch(data)
+	assert.NoError(t, err)
+
+	expected := &convertedCollection{
+		Version: currentCollectionVersion,
+		Collect: []*convertedBlock{
+			{
+				Domain: "org.apache.cassandra.db",
+				Beans: []*convertedBean{
+					{
+						Query:             "type=Caches,*",
+						Exclude:           []string{"[:,]keyspace=(?:system|system_auth)(?:,|$)"},
+						ExcludeAttributes: []string{"^(?:Hits)$"},
+						Attributes: []*convertedAttribute{
+							{Attr: "CapacityInBytes", MetricName: "cassandra.{type}.capacity", MetricType: "gauge"},
+							{Attr: "Requests", MetricType: "rate"},
+						},
+					},
+				},
+			},
+			{
+				Domain: "org.apache.cassandra.metrics",
+				Beans: []*convertedBean{
+					{
+						Query:         "name=Latency,scope=*,type=ClientRequest,*",
+						Include:       map[string]string{"scope": "^(?:Read|Write)$"},
+						Tags:          map[string]string{"tier": "storage"},
+						KeyProperties: &convertedKeyProperties{Rename: map[string]string{"scope": "request"}},
+						Attributes: []*convertedAttribute{
+							{Attr: "Count", MetricName: "cassandra.{scope}.latency.count", MetricType: "delta"},
+						},
+					},
+					{
+						Query:      "*",
+						QueryRegex: `^(?:org\.apache\.cassandra\.metrics:type=Table,.*)$`,
+						Attributes: []*convertedAttribute{{Attr: "LiveSSTableCount"}},
+					},
+				},
+			},
+			{
+				Domain: "java.lang",
+				Beans: []*convertedBean{
+					{
+						Query:      "type=Memory",
+						Attributes: []*convertedAttribute{{Attr: "HeapMemoryUsage.Used", MetricName: "java.lang.heap.used"}},
+					},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, collection)
+
+	var messages []string
+	for _, warning := range warnings {
+		messages = append(messages, warning.String())
+	}
+	assert.Equal(t, []string{
+		"init_config: collect_default_metrics is not converted, use COLLECTION_PRESETS=jvm for the JVM metrics",
+		"init_config conf 4: include option class is not supported, it is ignored",
+		"init_config conf 5: entries without an include filter are not converted",
+		"instance 1: instance tags are not converted, set them with CUSTOM_ATTRIBUTES",
+	}, messages)
+}
+
+func TestConvertJmxFetch_Exclude(t *testing.T) {
+	collection, warnings, err := convertJmxFetch([]byte(`init_config:
+  conf:
+    - include:
+        domain: kafka.server
+      exclude:
+        domain_regex: 'kafka\.(log|cluster)'
+        bean: kafka.server:type=app-info
+        bean_regex: 'kafka\.server:type=.*Quota.*'
+        attribute:
+          Version: {}
+`))
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, []string{
+		`^(?:kafka\.(log|cluster)):`,
+		`^kafka\.server:type=app-info$`,
+		`^(?:kafka\.server:type=.*Quota.*)$`,
+	}, collection.Collect[0].Beans[0].Exclude)
+	assert.Equal(t, []string{`^(?:Version)$`}, collection.Collect[0].Beans[0].ExcludeAttributes)
+	assert.Nil(t, collection.Collect[0].Beans[0].Attributes)
+}
+
+func TestConvertJmxFetch_Warnings(t *testing.T) {
+	testCases := []struct {
+		name    string
+		conf    string
+		warning string
+	}{
+		{
+			"unsupported metric type",
+			"{include: {domain: a, attribute: {Value: {metric_type: histogram}}}}",
+			"init_config conf 1: metric_type histogram of attribute Value is not supported, the metric type is inferred",
+		},
+		{
+			"domain without a single domain",
+			"{include: {domain: [a, b], attribute: {Value: {alias: $domain.value}}}}",
+			`init_config conf 1: alias "$domain.value" references $domain, which can't be resolved without a single domain, it is left out`,
+		},
+		{
+			"computed tag",
+			"{include: {domain: a, tags: {kind: 'jvm_$type'}}}",
+			`init_config conf 1: tag kind="jvm_$type" can't be translated, only tags set to a key property are`,
+		},
+		{
+			"inexact domain regex",
+			`{include: {domain_regex: 'kafka\.\w+'}}`,
+			`init_config conf 1: domain_regex "kafka\\.\\w+" is converted to the domain "kafka.*", which may match more domains`,
+		},
+		{
+			"java regex",
+			`{include: {bean_regex: 'a:type=(?<!b)c'}}`,
+			`init_config conf 1: bean_regex "a:type=(?<!b)c" can't be used in Go, it is not converted`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, warnings, err := convertJmxFetch([]byte("init_config:\n  conf:\n    - " + tc.conf + "\n"))
+			assert.NoError(t, err)
+			var messages []string
+			for _, warning := range warnings {
+				messages = append(messages, warning.String())
+			}
+			assert.Contains(t, messages, tc.warning)
+		})
+	}
+}
+
+func TestRunConversion_JmxFetch(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := runConversion(filepath.Join("..", "test", "data", "jmxfetch-cassandra.yml"), convertJmxFetch, stdout, stderr)
+	assert.Equal(t, 0, code)
+	assert.NotContains(t, stderr.String(), "converted collection")
+
+	c, err := parseCollectionBytes(stdout.Bytes(), "converted")
+	assert.NoError(t, err)
+	assert.Equal(t, currentCollectionVersion, c.Version)
+	domains, err := parseCollectionDefinition(c)
+	assert.NoError(t, err)
+	assert.Len(t, domains, 3)
+}
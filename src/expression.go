@@ -0,0 +1,606 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrUnknownReference = errors.New("unknown reference")
+)
+
+// expression is a parsed expression, like HeapMemoryUsage.Used / HeapMemoryUsage.Max * 100.
+// References are resolved when it is evaluated.
+type expression struct {
+	raw  string
+	root exprNode
+}
+
+// exprNode is a node of the syntax tree of an expression
+type exprNode interface {
+	eval(env *exprEnv) (interface{}, error)
+}
+
+// exprEnv resolves the references and the context functions of an expression
+type exprEnv struct {
+	lookup func(ref string) (interface{}, bool)
+	// funcs are the functions without a call in exprFunctions, like has_bean
+	funcs map[string]exprFunc
+}
+
+type exprFunc func(args []interface{}) (interface{}, error)
+
+// exprFunction is a function expressions can call with arity arguments.
+// Functions without call depend on where the expression is evaluated.
+type exprFunction struct {
+	arity int
+	call  exprFunc
+}
+
+// exprFunctions are the functions expressions can call
+var exprFunctions = map[string]exprFunction{
+	"contains":    {2, stringFunc(strings.Contains)},
+	"starts_with": {2, stringFunc(strings.HasPrefix)},
+	"ends_with":   {2, stringFunc(strings.HasSuffix)},
+	"matches":     {2, matchesFunc},
+	"major":       {1, majorFunc},
+	"has_domain":  {arity: 1},
+	"has_bean":    {arity: 1},
+}
+
+type numberNode float64
+
+type stringNode string
+
+type refNode string
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+// parseExpression parses an expression made of numbers, 'strings', references to
+// attributes, the + - * / % arithmetic operators, the == != < <= > >= comparisons,
+// the && || ! logical operators, function calls and parentheses. References are
+// attribute names, quoted with backticks if they contain other characters than
+// letters, digits, '_' and '.'
+func parseExpression(raw string) (*expression, error) {
+	tokens, err := tokenizeExpression(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", raw, err)
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseBinary(0)
+	if err == nil && !p.done() {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", raw, err)
+	}
+	return &expression{raw: raw, root: root}, nil
+}
+
+// evalNumber evaluates the expression, resolving references with lookup
+func (e *expression) evalNumber(lookup func(ref string) (interface{}, bool)) (float64, error) {
+	v, err := e.root.eval(&exprEnv{lookup: lookup})
+	if err != nil {
+		return 0, err
+	}
+	return toNumber(v)
+}
+
+// evalBool evaluates the expression as a condition, resolving references
+// with lookup and the context functions with funcs
+func (e *expression) evalBool(lookup func(ref string) (interface{}, bool), funcs map[string]exprFunc) (bool, error) {
+	v, err := e.root.eval(&exprEnv{lookup: lookup, funcs: funcs})
+	if err != nil {
+		return false, err
+	}
+	return toBool(v)
+}
+
+// refs returns the references used in the expression
+func (e *expression) refs() []string {
+	var refs []string
+	var walk func(n exprNode)
+	walk = func(n exprNode) {
+		switch node := n.(type) {
+		case refNode:
+			refs = append(refs, string(node))
+		case *unaryNode:
+			walk(node.operand)
+		case *binaryNode:
+			walk(node.left)
+			walk(node.right)
+		case *callNode:
+			for _, arg := range node.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(e.root)
+	return refs
+}
+
+// calls returns the names of the functions called in the expression
+func (e *expression) calls() []string {
+	var calls []string
+	var walk func(n exprNode)
+	walk = func(n exprNode) {
+		switch node := n.(type) {
+		case *unaryNode:
+			walk(node.operand)
+		case *binaryNode:
+			walk(node.left)
+			walk(node.right)
+		case *callNode:
+			calls = append(calls, node.name)
+			for _, arg := range node.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(e.root)
+	return calls
+}
+
+func (n numberNode) eval(*exprEnv) (interface{}, error) {
+	return float64(n), nil
+}
+
+func (n stringNode) eval(*exprEnv) (interface{}, error) {
+	return string(n), nil
+}
+
+func (n refNode) eval(env *exprEnv) (interface{}, error) {
+	v, ok := env.lookup(string(n))
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownReference, string(n))
+	}
+	return v, nil
+}
+
+func (n *callNode) eval(env *exprEnv) (interface{}, error) {
+	call := env.funcs[n.name]
+	if call == nil {
+		call = exprFunctions[n.name].call
+	}
+	if call == nil {
+		return nil, fmt.Errorf("%s() can't be used here", n.name)
+	}
+
+	args := make([]interface{}, 0, len(n.args))
+	for _, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return call(args)
+}
+
+func (n *unaryNode) eval(env *exprEnv) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		b, err := toBool(v)
+		if err != nil {
+			return nil, err
+		}
+		return !b, nil
+	}
+	f, err := toNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	return -f, nil
+}
+
+func (n *binaryNode) eval(env *exprEnv) (interface{}, error) {
+	lv, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// The logical operators only evaluate their right side when needed
+	if n.op == "&&" || n.op == "||" {
+		l, err := toBool(lv)
+		if err != nil || l == (n.op == "||") {
+			return l, err
+		}
+		rv, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return toBool(rv)
+	}
+
+	rv, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := comparisonOperators[n.op]; ok {
+		return compareValues(n.op, lv, rv), nil
+	}
+
+	l, err := toNumber(lv)
+	if err != nil {
+		return nil, err
+	}
+	r, err := toNumber(rv)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if n.op == "%" {
+			return math.Mod(l, r), nil
+		}
+		return l / r, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+// toNumber converts an evaluated value to a number. Strings holding a number are
+// converted too, and booleans are 1 or 0.
+func toNumber(v interface{}) (float64, error) {
+	if f, ok := toFloat64(v); ok {
+		return f, nil
+	}
+	if b, ok := v.(bool); ok {
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	if s, ok := v.(string); ok {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// toBool converts an evaluated value to a boolean. Numbers are true unless
+// they are 0, and strings must be true or false.
+func toBool(v interface{}) (bool, error) {
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		if parsed, err := strconv.ParseBool(strings.TrimSpace(b)); err == nil {
+			return parsed, nil
+		}
+	default:
+		if f, ok := toFloat64(v); ok {
+			return f != 0, nil
+		}
+	}
+	return false, fmt.Errorf("%v is not a boolean", v)
+}
+
+// comparisonOperators are the operators compareValues implements
+var comparisonOperators = map[string]struct{}{"==": {}, "!=": {}, "<": {}, "<=": {}, ">": {}, ">=": {}}
+
+// compareValues compares two evaluated values, as numbers if both are numbers,
+// like a numeric attribute and "1.8", and as strings otherwise
+func compareValues(op string, lv, rv interface{}) bool {
+	var cmp int
+	l, lErr := toNumber(lv)
+	r, rErr := toNumber(rv)
+	switch {
+	case lErr == nil && rErr == nil && l < r:
+		cmp = -1
+	case lErr == nil && rErr == nil && l > r:
+		cmp = 1
+	case lErr != nil || rErr != nil:
+		cmp = strings.Compare(fmt.Sprintf("%v", lv), fmt.Sprintf("%v", rv))
+	}
+
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// stringFunc makes an expression function of a string predicate, like strings.Contains
+func stringFunc(f func(s, substr string) bool) exprFunc {
+	return func(args []interface{}) (interface{}, error) {
+		return f(fmt.Sprintf("%v", args[0]), fmt.Sprintf("%v", args[1])), nil
+	}
+}
+
+// matchesFunc tells whether a value matches a regular expression
+func matchesFunc(args []interface{}) (interface{}, error) {
+	r, err := regexp.Compile(fmt.Sprintf("%v", args[1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex pattern %v", args[1])
+	}
+	return r.MatchString(fmt.Sprintf("%v", args[0])), nil
+}
+
+// majorFunc returns the major version of a Java version, like 8 for 1.8.0_292 or 17 for 17.0.2
+func majorFunc(args []interface{}) (interface{}, error) {
+	version := strings.TrimPrefix(fmt.Sprintf("%v", args[0]), "1.")
+	end := 0
+	for end < len(version) && version[end] >= '0' && version[end] <= '9' {
+		end++
+	}
+	major, err := strconv.Atoi(version[:end])
+	if err != nil {
+		return nil, fmt.Errorf("%v is not a Java version", args[0])
+	}
+	return float64(major), nil
+}
+
+type tokenKind int
+
+const (
+	tokenNumber tokenKind = iota
+	tokenString
+	tokenRef
+	tokenOperator
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+}
+
+// exprOperators are the operators in the order they are tokenized,
+// longer operators first
+var exprOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", ","}
+
+func tokenizeExpression(raw string) ([]exprToken, error) {
+	var tokens []exprToken
+	runes := []rune(raw)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '`':
+			end := i + 1
+			for end < len(runes) && runes[end] != '`' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unclosed '`'")
+			}
+			tokens = append(tokens, exprToken{kind: tokenRef, text: string(runes[i+1 : end])})
+			i = end + 1
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unclosed '%c'", r)
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, text: string(runes[i+1 : end])})
+			i = end + 1
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			// Exponent, as in 1e-3
+			if end < len(runes) && (runes[end] == 'e' || runes[end] == 'E') {
+				exp := end + 1
+				if exp < len(runes) && (runes[exp] == '+' || runes[exp] == '-') {
+					exp++
+				}
+				if exp < len(runes) && unicode.IsDigit(runes[exp]) {
+					for exp < len(runes) && unicode.IsDigit(runes[exp]) {
+						exp++
+					}
+					end = exp
+				}
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: string(runes[i:end])})
+			i = end
+		case isRefRune(r, true):
+			end := i
+			for end < len(runes) && isRefRune(runes[end], false) {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: tokenRef, text: string(runes[i:end])})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range exprOperators {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q", r)
+			}
+			tokens = append(tokens, exprToken{kind: tokenOperator, text: op})
+			i += len([]rune(op))
+		}
+	}
+	return tokens, nil
+}
+
+func isRefRune(r rune, first bool) bool {
+	if unicode.IsLetter(r) || r == '_' {
+		return true
+	}
+	return !first && (unicode.IsDigit(r) || r == '.')
+}
+
+// exprParser is a precedence climbing parser over the tokens of an expression
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+// binaryPrecedence of each binary operator, higher binds tighter
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+func (p *exprParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+// at tells whether the next token is the operator op
+func (p *exprParser) at(op string) bool {
+	return !p.done() && p.peek().kind == tokenOperator && p.peek().text == op
+}
+
+func (p *exprParser) parseBinary(minPrecedence int) (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for !p.done() {
+		token := p.peek()
+		precedence, ok := binaryPrecedence[token.text]
+		if token.kind != tokenOperator || !ok || precedence < minPrecedence {
+			break
+		}
+		p.pos++
+		right, err := p.parseBinary(precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: token.text, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.done() {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	token := p.peek()
+	if token.kind == tokenOperator && (token.text == "-" || token.text == "!") {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: token.text, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	token := p.peek()
+	p.pos++
+	switch token.kind {
+	case tokenNumber:
+		f, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token.text)
+		}
+		return numberNode(f), nil
+	case tokenString:
+		return stringNode(token.text), nil
+	case tokenRef:
+		if p.at("(") {
+			return p.parseCall(token.text)
+		}
+		return refNode(token.text), nil
+	}
+
+	if token.text == "(" {
+		inner, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if !p.at(")") {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", token.text)
+}
+
+// parseCall parses the arguments of a call to the function name, after its name
+func (p *exprParser) parseCall(name string) (exprNode, error) {
+	function, ok := exprFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	p.pos++
+
+	call := &callNode{name: name}
+	for !p.done() && !p.at(")") {
+		if len(call.args) > 0 {
+			if !p.at(",") {
+				return nil, fmt.Errorf("unexpected %q", p.peek().text)
+			}
+			p.pos++
+		}
+		arg, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+	}
+	if p.done() {
+		return nil, fmt.Errorf("missing ')'")
+	}
+	p.pos++
+
+	if len(call.args) != function.arity {
+		return nil, fmt.Errorf("%s() takes %d arguments, got %d", name, function.arity, len(call.args))
+	}
+	return call, nil
+}
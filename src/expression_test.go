@@ -0,0 +1,133 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpression(t *testing.T) {
+	values := map[string]interface{}{
+		"HeapMemoryUsage.Used": int64(256),
+		"HeapMemoryUsage.Max":  int64(1024),
+		"currentThreadsBusy":   int64(3),
+		"maxThreads":           "200",
+		"Odd Name":             2.0,
+		"Zero":                 0,
+	}
+	lookup := func(ref string) (interface{}, bool) {
+		v, ok := values[ref]
+		return v, ok
+	}
+
+	testCases := []struct {
+		expr        string
+		expected    float64
+		expectedErr bool
+	}{
+		{"HeapMemoryUsage.Used / HeapMemoryUsage.Max * 100", 25, false},
+		{"currentThreadsBusy / maxThreads", 0.015, false},
+		{"1 + 2 * 3", 7, false},
+		{"(1 + 2) * 3", 9, false},
+		{"10 - 4 - 3", 3, false},
+		{"-`Odd Name` + 1e1", 8, false},
+		{"7 % 4", 3, false},
+		{"HeapMemoryUsage.Used / Zero", 0, true},
+		{"Missing * 2", 0, true},
+	}
+
+	for _, tc := range testCases {
+		expr, err := parseExpression(tc.expr)
+		assert.NoError(t, err, tc.expr)
+
+		out, err := expr.evalNumber(lookup)
+		assert.Equal(t, tc.expectedErr, err != nil, tc.expr)
+		assert.InDelta(t, tc.expected, out, 1e-9, tc.expr)
+	}
+}
+
+func TestParseExpression_Errors(t *testing.T) {
+	for _, raw := range []string{"", "1 +", "(1 + 2", "1 2", "a $ b", "`unclosed", "* 2"} {
+		_, err := parseExpression(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestExpressionRefs(t *testing.T) {
+	expr, err := parseExpression("(a.b + `c d`) / -e * 2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.b", "c d", "e"}, expr.refs())
+}
+
+func TestExpression_Conditions(t *testing.T) {
+	values := map[string]interface{}{
+		"SpecVersion": "1.8",
+		"VmVendor":    "Eclipse Adoptium",
+		"VmVersion":   "17.0.2+8",
+		"Count":       int64(3),
+	}
+	lookup := func(ref string) (interface{}, bool) {
+		v, ok := values[ref]
+		return v, ok
+	}
+
+	testCases := []struct {
+		expr        string
+		expected    bool
+		expectedErr bool
+	}{
+		{"SpecVersion == '1.8'", true, false},
+		{`SpecVersion == "1.80"`, true, false},
+		{"SpecVersion != 1.8", false, false},
+		{"major(SpecVersion) < 11 && major(VmVersion) >= 17", true, false},
+		{"VmVendor == 'Oracle Corporation' || starts_with(VmVendor, 'Eclipse')", true, false},
+		{"!contains(VmVendor, 'Oracle')", true, false},
+		{"matches(VmVersion, '^17\\.') && ends_with(VmVersion, '+8')", true, false},
+		{"Count > 2 && Count <= 3", true, false},
+		{"Count - 3", false, false},
+		{"'b' > 'a'", true, false},
+		// The right side isn't evaluated when the left side decides the result
+		{"Count > 5 && Missing > 1", false, false},
+		{"Count < 5 || Missing > 1", true, false},
+		{"Missing > 1", false, true},
+		{"VmVendor", false, true},
+		{"major(VmVendor) > 8", false, true},
+		{"matches(VmVendor, '[')", false, true},
+		{"has_bean('java.lang:*')", false, true},
+	}
+
+	for _, tc := range testCases {
+		expr, err := parseExpression(tc.expr)
+		if !assert.NoError(t, err, tc.expr) {
+			continue
+		}
+
+		out, err := expr.evalBool(lookup, nil)
+		assert.Equal(t, tc.expectedErr, err != nil, tc.expr)
+		assert.Equal(t, tc.expected, out, tc.expr)
+	}
+
+	// Comparisons are 1 or 0 in derived metrics
+	expr, err := parseExpression("(Count > 2) * 10")
+	assert.NoError(t, err)
+	out, err := expr.evalNumber(lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, 10.0, out)
+}
+
+func TestParseExpression_CallErrors(t *testing.T) {
+	for _, raw := range []string{"unknown(1)", "major()", "contains('a')", "major(1, 2)", "major(1", "contains('a' 'b')", "'unclosed"} {
+		_, err := parseExpression(raw)
+		assert.Error(t, err, raw)
+	}
+
+	expr, err := parseExpression("has_bean('java.lang:type=Memory') && major(SpecVersion) > 8")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"has_bean", "major"}, expr.calls())
+	assert.Equal(t, []string{"SpecVersion"}, expr.refs())
+}
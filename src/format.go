@@ -6,6 +6,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -97,7 +98,11 @@ func parseArgsFromConfig(config []byte, integrationName, fileName string) error
 
 func setArgs(configOptions map[string]interface{}) error {
 	for optionName, option := range configOptions {
-		os.Setenv(strings.ToUpper(optionName), fmt.Sprintf("%v", option))
+		value, err := argValue(option)
+		if err != nil {
+			return fmt.Errorf("%w: invalid value of %s: %v", ErrConfig, optionName, err)
+		}
+		os.Setenv(strings.ToUpper(optionName), value)
 	}
 
 	// Overwrite all flag values with env vars.
@@ -113,6 +118,21 @@ func setArgs(configOptions map[string]interface{}) error {
 	return nil
 }
 
+// argValue formats the value of a config option as an env var. Maps and lists,
+// like a COLLECTION_CONFIG written as yaml, are passed as JSON.
+func argValue(option interface{}) (string, error) {
+	switch option.(type) {
+	case map[string]interface{}, []interface{}:
+		value, err := json.Marshal(option)
+		if err != nil {
+			return "", err
+		}
+		return string(value), nil
+	default:
+		return fmt.Sprintf("%v", option), nil
+	}
+}
+
 type instance struct {
 	Name      string
 	Arguments map[string]interface{}
@@ -0,0 +1,45 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestParseArgsFromConfig_CollectionConfigMap(t *testing.T) {
+	config := []byte(`integrations:
+  - name: nri-jmx
+    env:
+      JMX_PORT: 9999
+      COLLECTION_CONFIG:
+        collect:
+          - domain: java.lang
+            event_type: JVMSample
+            beans:
+              - query: type=Threading
+                attributes: [ThreadCount]
+`)
+	cfg := configFile{fileName: "jmx-config.yml"}
+	assert.NoError(t, yaml.Unmarshal(config, &cfg))
+	options, err := cfg.toConfigOptions()
+	assert.NoError(t, err)
+
+	port, err := argValue(options["JMX_PORT"])
+	assert.NoError(t, err)
+	assert.Equal(t, "9999", port)
+
+	collectionConfig, err := argValue(options["COLLECTION_CONFIG"])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"collect": [{"domain": "java.lang", "event_type": "JVMSample",
+		"beans": [{"query": "type=Threading", "attributes": ["ThreadCount"]}]}]}`, collectionConfig)
+
+	c, err := parseJSON(collectionConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "JVMSample", c.Collect[0].EventType)
+}
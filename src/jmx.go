@@ -10,7 +10,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -19,12 +18,16 @@ import (
 	"github.com/newrelic/nrjmx/gojmx"
 
 	sdkArgs "github.com/newrelic/infra-integrations-sdk/args"
+	"github.com/newrelic/infra-integrations-sdk/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/infra-integrations-sdk/log"
 )
 
 const (
 	integrationName = "com.newrelic.jmx"
+	// collectionFilesEventType is the event type of the sample reporting the status of the collection files
+	collectionFilesEventType = "JMXCollectionFilesSample"
 )
 
 type argumentList struct {
@@ -43,8 +46,14 @@ type argumentList struct {
 	KeyStorePassword         string `default:"" help:"Password for the SSL Key Store"`
 	TrustStore               string `default:"" help:"The location for the keystore containing JMX Server's SSL certificate"`
 	TrustStorePassword       string `default:"" help:"Password for the SSL Trust Store"`
-	CollectionFiles          string `default:"" help:"A comma separated list of full paths to metrics collections configuration files"`
-	CollectionConfig         string `default:"" help:"JSON format metrics collection configuration"`
+	CollectionFiles          string `default:"" help:"A comma separated list of metrics collections configuration files, directories or glob patterns. Relative paths are resolved against the directory of the config file"`
+	CollectionConfig         string `default:"" help:"Metrics collection configuration, as JSON or yaml. It can be encoded as base64:<payload> or gzip+base64:<payload>, and in the integrations config it can be written as a yaml map"`
+	CollectionPresets        string `default:"" help:"A comma separated list of built-in collection presets to collect: activemq, cassandra, hikaricp, jetty, jvm, kafka-broker, kafka-consumer, kafka-producer, solr, tomcat, wildfly, zookeeper"`
+	ConvertJmxExporter       string `default:"" help:"Convert the rules of the given Prometheus jmx_exporter configuration file to a collection file, print it and exit"`
+	ConvertJmxFetch          string `default:"" help:"Convert the include and exclude filters of the given Datadog JMXFetch configuration file to a collection file, print it and exit"`
+	PrintPreset              string `default:"" help:"Print the given built-in collection preset and exit, as a starting point for a collection file"`
+	PrintCollectionSchema    bool   `default:"false" help:"Print the JSON Schema of collection files and exit, to validate them in editors and configuration pipelines"`
+	MigrateCollection        string `default:"" help:"Rewrite the given collection file to the newest version of the collection format, print the differences and exit"`
 	NrJmx                    string `default:"/usr/bin/nrjmx" help:"nrjmx tool executable path"`
 	ConnectionURL            string `default:"" help:"full connection URL"`
 	Query                    string `default:"" help:"For troubleshooting only: Connect to the JMX endpoint and execute the query. Query format DOMAIN:BEAN"`
@@ -59,6 +68,13 @@ type argumentList struct {
 	HeartbeatInterval        int    `default:"5" help:"BETA: Interval in seconds for submitting the heartbeat while in long-running mode"`
 	Interval                 int    `default:"30" help:"BETA: Interval in seconds for collecting data while while in long-running mode"`
 	EnableInternalStats      bool   `default:"false" help:"Print nrjmx internal query stats for troubleshooting"`
+	ValidateCollections      bool   `default:"false" help:"Check the collection files, collection config and collection presets, print every problem found and exit without connecting to JMX"`
+	StrictCollectionFiles    bool   `default:"false" help:"Exit when any collection file can't be loaded, instead of skipping it and collecting the others"`
+	CustomAttributes         string `default:"" help:"JSON object of attributes added to every sample, like {\"team\":\"payments\"}. Tags of collect blocks and beans override them"`
+	EntityNameTemplate       string `default:"" help:"Template for the name of the entities the metrics are reported on, like {domain}:{host}:{port}. It can use {domain}, {host}, {port}, {connection_url} and the bean key properties, like {type}. Domains with the same name share the entity"`
+	EntityType               string `default:"jmx-domain" help:"Type of the entities the metrics are reported on"`
+	TypeInference            string `default:"default" help:"How the type of the attributes without a metric_type is inferred: default reports numbers as gauges, heuristic reports the numbers matching TYPE_INFERENCE_RULES, like cumulative counters, as rates and deltas. The type_inference of a collect block overrides it"`
+	TypeInferenceRules       string `default:"" help:"Comma separated attrGlob[@beanGlob]:type rules of the heuristic type inference, like *Count@type=GarbageCollector:rate. The first matching rule is used. Attribute globs are case insensitive, bean globs match a key property. Replaces the built-in rules"`
 }
 
 var (
@@ -68,6 +84,11 @@ var (
 	buildDate          = ""
 
 	errNRJMXNotRunning = errors.New("nrjmx client sub-process not running")
+
+	// customAttributes are the attributes from CUSTOM_ATTRIBUTES added to every sample
+	customAttributes map[string]string
+	// entityNameTemplate is the parsed ENTITY_NAME_TEMPLATE, nil to use the default entity names
+	entityNameTemplate *nameTemplate
 )
 
 func main() {
@@ -101,12 +122,70 @@ func main() {
 
 	log.SetupLogging(args.Verbose)
 
+	if args.ConvertJmxExporter != "" {
+		os.Exit(runConversion(args.ConvertJmxExporter, convertJmxExporter, os.Stdout, os.Stderr))
+	}
+
+	if args.ConvertJmxFetch != "" {
+		os.Exit(runConversion(args.ConvertJmxFetch, convertJmxFetch, os.Stdout, os.Stderr))
+	}
+
+	if args.PrintPreset != "" {
+		preset, err := presetSource(args.PrintPreset)
+		fatalIfErr(err)
+		fmt.Print(string(preset))
+		os.Exit(0)
+	}
+
+	if args.MigrateCollection != "" {
+		os.Exit(runMigration(args.MigrateCollection, os.Stdout, os.Stderr))
+	}
+
+	if args.PrintCollectionSchema {
+		schema, err := collectionJSONSchema()
+		fatalIfErr(err)
+		fmt.Print(string(schema))
+		os.Exit(0)
+	}
+
 	// Ensure a collection file is specified
-	if args.CollectionFiles == "" && args.CollectionConfig == "" {
-		log.Error("Must specify at least one collection file or a collection config JSON")
+	if args.CollectionFiles == "" && args.CollectionConfig == "" && args.CollectionPresets == "" {
+		log.Error("Must specify at least one collection file, a collection config JSON or a collection preset")
+		os.Exit(1)
+	}
+
+	customAttributes, err = parseCustomAttributes(args.CustomAttributes)
+	if err != nil {
+		log.Error("Failed to parse custom attributes: %s", err)
 		os.Exit(1)
 	}
 
+	if args.EntityNameTemplate != "" {
+		entityNameTemplate, err = parseBeanTemplate(args.EntityNameTemplate, "entity_name_template")
+		if err != nil {
+			log.Error("Failed to parse the entity name template: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := checkTypeInference(args.TypeInference); err != nil {
+		log.Error("Invalid type inference: %s", err)
+		os.Exit(1)
+	}
+	rules := args.TypeInferenceRules
+	if rules == "" {
+		rules = defaultTypeInferenceRules
+	}
+	typeInferenceRules, err = parseTypeInferenceRules(rules)
+	if err != nil {
+		log.Error("Failed to parse the type inference rules: %s", err)
+		os.Exit(1)
+	}
+
+	if args.ValidateCollections {
+		os.Exit(runValidation(os.Stdout))
+	}
+
 	jmxClient, err = openJMXConnection()
 	if err != nil {
 		log.Error("Failed to open JMX connection, error: %v, Config: (%s)",
@@ -136,63 +215,138 @@ func main() {
 }
 
 // runCollectionFiles will run the collection for collection files configuration.
-func runCollectionFiles(jmxIntegration *integration.Integration, client Client) {
+// Files that can't be loaded are skipped, unless StrictCollectionFiles is set.
+// It returns how many files were loaded and how many failed to.
+func runCollectionFiles(jmxIntegration *integration.Integration, client Client, facts *jvmFacts) (loaded, failed int) {
 	if args.CollectionFiles == "" {
-		return
+		return 0, 0
+	}
+	// Expand the directories and patterns of the list
+	collectionFiles, err := expandCollectionFiles(args.CollectionFiles, args.ConfigFile)
+	if err != nil {
+		log.Error("Invalid metrics collection files: %s", err)
+		os.Exit(1)
 	}
-	// For each collection definition file, parse and collect it
-	collectionFiles := strings.Split(args.CollectionFiles, ",")
 
-	for _, collectionFile := range collectionFiles {
-		// Check that the filepath is an absolute path
-		if !filepath.IsAbs(collectionFile) {
-			log.Error("Invalid metrics collection path %s. Metrics collection files must be specified as absolute paths.", collectionFile)
-			os.Exit(1)
+	collections, failed := loadCollectionFiles(collectionFiles)
+	if failed > 0 && args.StrictCollectionFiles {
+		log.Error("Failed to load %d of %d collection files", failed, len(collectionFiles))
+		os.Exit(1)
+	}
+
+	for _, collection := range collections {
+		if err := runCollection(collection, jmxIntegration, client, facts, args.JmxHost, args.JmxPort); err != nil {
+			log.Error("Failed to complete collection: %s", err)
 		}
+	}
+	return len(collections), failed
+}
 
-		// Parse the yaml file into a raw definition
-		collectionDefinition, err := parseYaml(collectionFile)
+// loadCollectionFiles parses and validates each collection file on its own. The files
+// that can't be loaded are logged and skipped, and their count is returned.
+func loadCollectionFiles(collectionFiles []string) (collections [][]*domainDefinition, failed int) {
+	for _, collectionFile := range collectionFiles {
+		// Parse the yaml file, and the files it includes and extends, into a raw definition
+		collectionDefinition, err := loadCollectionFile(collectionFile)
 		if err != nil {
-			log.Error("Failed to parse collection definition file %s: %s", collectionFile, err)
-			os.Exit(1)
+			log.Error("Skipping collection definition file %s, failed to parse it: %s", collectionFile, err)
+			failed++
+			continue
 		}
 
 		// Validate the definition and create a collection object
 		collection, err := parseCollectionDefinition(collectionDefinition)
 		if err != nil {
-			log.Error("Failed to parse collection definition %s: %s", collectionFile, err)
-			os.Exit(1)
+			log.Error("Skipping collection definition file %s, it is invalid: %s", collectionFile, err)
+			failed++
+			continue
 		}
+		collections = append(collections, collection)
+	}
+	return collections, failed
+}
 
-		if err := runCollection(collection, jmxIntegration, client, args.JmxHost, args.JmxPort); err != nil {
-			log.Error("Failed to complete collection: %s", err)
+// collectionStatus counts the collection sources of a run that were loaded and failed to
+type collectionStatus struct {
+	filesLoaded   int
+	filesFailed   int
+	configFailed  int
+	presetsFailed int
+}
+
+// insertCollectionFilesSample reports how many collection files, presets and
+// collection configs were loaded and how many failed to, so broken sources can be alerted on. It is reported
+// on every run, the failed counts being 0 when every source loaded.
+func insertCollectionFilesSample(i *integration.Integration, status collectionStatus) error {
+	ms := i.LocalEntity().NewMetricSet(collectionFilesEventType, attribute.Attribute{Key: "host", Value: args.JmxHost})
+	for name, value := range map[string]int{
+		"collectionFiles.loaded":   status.filesLoaded,
+		"collectionFiles.failed":   status.filesFailed,
+		"collectionConfig.failed":  status.configFailed,
+		"collectionPresets.failed": status.presetsFailed,
+	} {
+		if err := ms.SetMetric(name, value, metric.GAUGE); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-// runCollectionConfig will run the collection for JSON collection configuration
-func runCollectionConfig(jmxIntegration *integration.Integration, client Client) {
+// runCollectionConfig will run the collection for JSON collection configuration.
+// A config that can't be decoded or is invalid is skipped like collection files,
+// and it returns 1 when it is.
+func runCollectionConfig(jmxIntegration *integration.Integration, client Client, facts *jvmFacts) (failed int) {
 	if args.CollectionConfig == "" {
-		return
+		return 0
 	}
 
 	// Parse the JSON collection config into a raw definition
 	collectionDefinition, err := parseJSON(args.CollectionConfig)
+	if err == nil {
+		collectionDefinition, err = resolveComposition(collectionDefinition, "", []string{"COLLECTION_CONFIG"})
+	}
 	if err != nil {
-		log.Error("Failed to parse collection definition config %s: %s", args.CollectionConfig, err)
-		os.Exit(1)
+		log.Error("Skipping collection definition config %s, failed to parse it: %s", args.CollectionConfig, err)
+		return 1
 	}
 
 	// Validate the definition and create a collection object
 	collection, err := parseCollectionDefinition(collectionDefinition)
 	if err != nil {
-		log.Error("Failed to parse collection definition config %s: %s", args.CollectionConfig, err)
-		os.Exit(1)
+		log.Error("Skipping collection definition config %s, it is invalid: %s", args.CollectionConfig, err)
+		return 1
 	}
 
-	if err := runCollection(collection, jmxIntegration, client, args.JmxHost, args.JmxPort); err != nil {
+	if err := runCollection(collection, jmxIntegration, client, facts, args.JmxHost, args.JmxPort); err != nil {
 		log.Error("Failed to complete collection: %s", err)
 	}
+	return 0
+}
+
+// runCollectionPresets will run the collection for the built-in collection presets.
+// Presets that can't be loaded, like unknown ones, are skipped like collection files,
+// and their count is returned.
+func runCollectionPresets(jmxIntegration *integration.Integration, client Client, facts *jvmFacts) (failed int) {
+	for _, name := range splitPresets(args.CollectionPresets) {
+		collectionDefinition, err := loadPreset(name)
+		if err != nil {
+			log.Error("Skipping collection preset %s, failed to load it: %s", name, err)
+			failed++
+			continue
+		}
+
+		collection, err := parseCollectionDefinition(collectionDefinition)
+		if err != nil {
+			log.Error("Skipping collection preset %s, it is invalid: %s", name, err)
+			failed++
+			continue
+		}
+
+		if err := runCollection(collection, jmxIntegration, client, facts, args.JmxHost, args.JmxPort); err != nil {
+			log.Error("Failed to complete collection: %s", err)
+		}
+	}
+	return failed
 }
 
 // checkMetricLimit looks through all of the metric sets for every entity and aggregates the number
@@ -260,8 +414,17 @@ func collectMetrics(i *integration.Integration, jmxClient *gojmx.Client) error {
 		}()
 	}
 
-	runCollectionFiles(i, jmxClient)
-	runCollectionConfig(i, jmxClient)
+	// The facts when conditions are checked against are shared by every collection of the run
+	facts := newJVMFacts(jmxClient)
+	var status collectionStatus
+	status.filesLoaded, status.filesFailed = runCollectionFiles(i, jmxClient, facts)
+	status.configFailed = runCollectionConfig(i, jmxClient, facts)
+	status.presetsFailed = runCollectionPresets(i, jmxClient, facts)
+
+	// Reported last, so the domain entities come first in the payload
+	if err := insertCollectionFilesSample(i, status); err != nil {
+		log.Error("Failed to report the collection files status: %s", err)
+	}
 
 	return nil
 }
@@ -10,6 +10,8 @@ import (
 
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nrjmx/gojmx"
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_checkMetricList(t *testing.T) {
@@ -64,3 +66,57 @@ func Test_checkMetricList(t *testing.T) {
 		t.Errorf("Expected entity '%+v' got '%+v'", e1, out[0])
 	}
 }
+
+func Test_loadCollectionFiles(t *testing.T) {
+	collections, failed := loadCollectionFiles([]string{
+		"../test/data/test-sample.yml",
+		"../test/data/test-sample-nonexistant.yml",
+		"../test/data/test-sample-bad2.yml",
+		"../test/data/test-sample-included.yml",
+	})
+
+	assert.Equal(t, 2, failed)
+	assert.Len(t, collections, 2)
+	assert.Equal(t, "java.lang", collections[1][0].domain)
+}
+
+func Test_insertCollectionFilesSample(t *testing.T) {
+	args = argumentList{JmxHost: "localhost"}
+	i, err := integration.New("jmx", "1.0.0")
+	assert.NoError(t, err)
+
+	assert.NoError(t, insertCollectionFilesSample(i, collectionStatus{filesLoaded: 3, filesFailed: 1}))
+	assert.Equal(t, map[string]interface{}{
+		"event_type":               collectionFilesEventType,
+		"host":                     "localhost",
+		"collectionFiles.loaded":   3.0,
+		"collectionFiles.failed":   1.0,
+		"collectionConfig.failed":  0.0,
+		"collectionPresets.failed": 0.0,
+	}, i.LocalEntity().Metrics[0].Metrics)
+}
+
+func Test_runCollectionConfig_Undecodable(t *testing.T) {
+	i, err := integration.New("jmx", "1.0.0")
+	assert.NoError(t, err)
+	client := &jmxClientMock{}
+
+	for _, config := range []string{"base64:not base64", "gzip+base64:bm90IGd6aXA=", `{"collect": [{"domain": "test.*"}]}`} {
+		args = argumentList{JmxHost: "localhost", CollectionConfig: config}
+		assert.Equal(t, 1, runCollectionConfig(i, client, newJVMFacts(client)), config)
+	}
+}
+
+func Test_runCollectionPresets_Unknown(t *testing.T) {
+	args = argumentList{JmxHost: "localhost", CollectionPresets: "jvm,unknown"}
+	i, err := integration.New("jmx", "1.0.0")
+	assert.NoError(t, err)
+
+	// The unknown preset is skipped, and the others are still collected
+	client := &jmxClientMock{response: []*gojmx.AttributeResponse{
+		{Name: "java.lang:type=Threading,attr=ThreadCount", ResponseType: gojmx.ResponseTypeInt, IntValue: 12},
+	}}
+	assert.Equal(t, 1, runCollectionPresets(i, client, newJVMFacts(client)))
+	assert.Len(t, i.Entities, 1)
+	assert.Equal(t, 12.0, i.Entities[0].Metrics[0].Metrics["ThreadCount"])
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// collectionMigrations rewrite a collection definition from the version they
+// are indexed by to the next one
+var collectionMigrations = map[int]func(root *yaml.Node) error{
+	1: migrateToVersion2,
+}
+
+// runMigration rewrites the collection file to the newest version of the collection
+// format and writes the differences to stdout. It returns the exit code.
+func runMigration(file string, stdout, stderr io.Writer) int {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to read %s: %v\n", file, err)
+		return 1
+	}
+
+	migrated, err := migrateCollection(data)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to migrate %s: %v\n", file, err)
+		return 1
+	}
+	if migrated == nil {
+		fmt.Fprintf(stdout, "%s is already at version %d\n", file, currentCollectionVersion)
+		return 0
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to migrate %s: %v\n", file, err)
+		return 1
+	}
+	if err := os.WriteFile(file, migrated, info.Mode().Perm()); err != nil {
+		fmt.Fprintf(stderr, "failed to write %s: %v\n", file, err)
+		return 1
+	}
+
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(data)),
+		B:        difflib.SplitLines(string(migrated)),
+		FromFile: file,
+		ToFile:   fmt.Sprintf("%s (version %d)", file, currentCollectionVersion),
+		Context:  3,
+	})
+	fmt.Fprint(stdout, diff)
+	return 0
+}
+
+// migrateCollection rewrites a collection definition to the newest version of the
+// collection format, keeping its comments. It returns nil when it is already current.
+func migrateCollection(data []byte) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a collection definition")
+	}
+	root := document.Content[0]
+
+	version := 1
+	versionNode := mappingValue(root, "version")
+	if versionNode != nil {
+		v, err := strconv.Atoi(versionNode.Value)
+		if err != nil || v < 1 || v > currentCollectionVersion {
+			return nil, fmt.Errorf("unsupported version %s, expected one of %s", versionNode.Value, strings.Join(collectionVersions, ", "))
+		}
+		version = v
+	}
+	if version == currentCollectionVersion {
+		return nil, nil
+	}
+
+	for ; version < currentCollectionVersion; version++ {
+		if err := collectionMigrations[version](root); err != nil {
+			return nil, err
+		}
+	}
+
+	value := strconv.Itoa(currentCollectionVersion)
+	if versionNode != nil {
+		versionNode.Value, versionNode.Tag = value, "!!int"
+	} else {
+		root.Content = append([]*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"},
+			{Kind: yaml.ScalarNode, Tag: "!!int", Value: value},
+		}, root.Content...)
+	}
+
+	buf := &bytes.Buffer{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("---")) {
+		buf.WriteString("---\n")
+	}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(detectIndent(data))
+	if err := encoder.Encode(&document); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// migrateToVersion2 moves the exclude_regex patterns that target attributes, which
+// version 2 no longer matches against, to exclude_attributes
+func migrateToVersion2(root *yaml.Node) error {
+	var problems []string
+	for _, block := range sequenceItems(mappingValue(root, "collect")) {
+		for _, bean := range sequenceItems(mappingValue(block, "beans")) {
+			if err := migrateExcludeRegex(bean); err != nil {
+				problems = append(problems, fmt.Sprintf("line %d: %v", bean.Line, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// matchAnyPrefixes are the patterns before attr= that match any ObjectName
+var matchAnyPrefixes = map[string]bool{"": true, ",": true, ".*": true, ".*,": true, "^.*": true, "^.*,": true}
+
+// migrateExcludeRegex splits the version 1 exclude_regex patterns of a bean, which
+// match domain:bean,attr=name, into ObjectName and attribute name patterns. Patterns
+// that aren't anchored can match either, so they are kept and copied to exclude_attributes.
+func migrateExcludeRegex(bean *yaml.Node) error {
+	if bean.Kind != yaml.MappingNode {
+		return nil
+	}
+	index := mappingIndex(bean, "exclude_regex")
+	if index == -1 {
+		return nil
+	}
+	excludeNode := bean.Content[index+1]
+	patterns := []*yaml.Node{excludeNode}
+	if excludeNode.Kind == yaml.SequenceNode {
+		patterns = excludeNode.Content
+	}
+
+	var kept []*yaml.Node
+	var attributes []*yaml.Node
+	for _, pattern := range patterns {
+		p := pattern.Value
+		if i := strings.Index(p, "attr="); i != -1 {
+			if !matchAnyPrefixes[p[:i]] || p[i+len("attr="):] == "" {
+				return fmt.Errorf("exclude_regex %q can't be migrated, split it into an exclude_regex for the ObjectName and an exclude_attributes", p)
+			}
+			attributes = append(attributes, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "^" + p[i+len("attr="):], LineComment: pattern.LineComment})
+			continue
+		}
+		// Patterns anchored at the end matched the end of the attribute name
+		if strings.HasSuffix(p, "$") && !strings.HasSuffix(p, `\$`) {
+			attributes = append(attributes, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: p, LineComment: pattern.LineComment})
+			continue
+		}
+		if !strings.HasPrefix(p, "^") {
+			attributes = append(attributes, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: p})
+		}
+		kept = append(kept, pattern)
+	}
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	switch {
+	case len(kept) == 0:
+		bean.Content = append(bean.Content[:index], bean.Content[index+2:]...)
+	case excludeNode.Kind == yaml.SequenceNode:
+		excludeNode.Content = kept
+	}
+
+	if existing := mappingValue(bean, "exclude_attributes"); existing != nil {
+		if existing.Kind == yaml.ScalarNode {
+			copied := *existing
+			*existing = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{&copied}}
+		}
+		existing.Content = append(existing.Content, attributes...)
+		return nil
+	}
+	if len(kept) > 0 {
+		index += 2
+	}
+	entry := []*yaml.Node{
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: "exclude_attributes"},
+		{Kind: yaml.SequenceNode, Tag: "!!seq", Content: attributes},
+	}
+	bean.Content = append(bean.Content[:index], append(entry, bean.Content[index:]...)...)
+	return nil
+}
+
+// mappingIndex returns the index in the content of a mapping node of the given key, or -1
+func mappingIndex(node *yaml.Node, key string) int {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the value of a key of a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if i := mappingIndex(node, key); i != -1 {
+		return node.Content[i+1]
+	}
+	return nil
+}
+
+func sequenceItems(node *yaml.Node) []*yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return node.Content
+}
+
+// detectIndent returns the indentation of the first indented line of a yaml document
+func detectIndent(data []byte) int {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if indent := len(line) - len(trimmed); indent > 0 && trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return indent
+		}
+	}
+	return 2
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/newrelic/nrjmx/gojmx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateCollection(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "test", "data", "test-sample-v1.yml"))
+	assert.NoError(t, err)
+
+	migrated, err := migrateCollection(data)
+	assert.NoError(t, err)
+	assert.Equal(t, `---
+version: 2
+# Kafka broker beans
+collect:
+  - domain: kafka.server
+    beans:
+      - query: type=BrokerTopicMetrics,*
+        exclude_regex:
+          - name=.*Replication.* # replication traffic
+        exclude_attributes:
+          - name=.*Replication.*
+          - ^Mean.*
+          - ^.*Percentile$
+      - query: type=ReplicaManager,*
+        exclude_attributes:
+          - ^Min
+          - Count$
+      - query: type=Other,*
+        exclude_regex: name=Foo
+        exclude_attributes:
+          - name=Foo
+      - query: type=RequestMetrics,*
+        exclude_regex:
+          - requestCount
+          - ^kafka\.server:type=RequestMetrics,name=Old
+        exclude_attributes:
+          - requestCount
+`, string(migrated))
+
+	// The migrated file is valid and current
+	c, err := parseCollectionBytes(migrated, "migrated")
+	assert.NoError(t, err)
+	assert.Empty(t, c.problems)
+	assert.Equal(t, currentCollectionVersion, c.Version)
+	assert.Equal(t, currentCollectionVersion, c.Collect[0].Beans[0].version)
+
+	migrated, err = migrateCollection(migrated)
+	assert.NoError(t, err)
+	assert.Nil(t, migrated)
+}
+
+func TestMigrateCollection_SameExclusions(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "test", "data", "test-sample-v1.yml"))
+	assert.NoError(t, err)
+	migrated, err := migrateCollection(data)
+	assert.NoError(t, err)
+
+	jmxAttributes := []*gojmx.AttributeResponse{
+		{Name: "kafka.server:type=BrokerTopicMetrics,name=ReplicationBytesInPerSec,attr=Count"},
+		{Name: "kafka.server:type=BrokerTopicMetrics,name=BytesInPerSec,attr=Count"},
+		{Name: "kafka.server:type=BrokerTopicMetrics,name=BytesInPerSec,attr=MeanRate"},
+		{Name: "kafka.server:type=BrokerTopicMetrics,name=BytesInPerSec,attr=99thPercentile"},
+		{Name: "kafka.server:type=ReplicaManager,name=PartitionCount,attr=Value"},
+		{Name: "kafka.server:type=ReplicaManager,name=Partitions,attr=MinValue"},
+		{Name: "kafka.server:type=ReplicaManager,name=Partitions,attr=UnderReplicatedCount"},
+		{Name: "kafka.server:type=Other,name=Foo,attr=Value"},
+		{Name: "kafka.server:type=Other,name=Bar,attr=Value"},
+		{Name: "kafka.server:type=RequestMetrics,name=Produce,attr=requestCount"},
+		{Name: "kafka.server:type=RequestMetrics,name=requestCount,attr=Value"},
+		{Name: "kafka.server:type=RequestMetrics,name=Old,attr=Value"},
+		{Name: "kafka.server:type=RequestMetrics,name=Fetch,attr=Value"},
+	}
+
+	// collected returns the attributes collected by each bean of the collection file
+	collected := func(data []byte) [][]string {
+		c, err := parseCollectionBytes(data, "collection")
+		assert.NoError(t, err)
+		domains, err := parseCollectionDefinition(c)
+		assert.NoError(t, err)
+
+		var result [][]string
+		for _, request := range domains[0].beans {
+			var names []string
+			for _, jmxAttr := range jmxAttributes {
+				if matchRequest(jmxAttr, request) != nil {
+					names = append(names, jmxAttr.Name)
+				}
+			}
+			result = append(result, names)
+		}
+		return result
+	}
+
+	before := collected(data)
+	assert.Len(t, before, 4)
+	assert.NotContains(t, before[3], jmxAttributes[9].Name)
+	assert.Equal(t, before, collected(migrated))
+}
+
+func TestMigrateCollection_Errors(t *testing.T) {
+	_, err := migrateCollection([]byte("collect:\n  - domain: a\n    beans:\n      - query: '*'\n        exclude_regex: type=Cache,attr=hitCount\n"))
+	assert.EqualError(t, err, `line 4: exclude_regex "type=Cache,attr=hitCount" can't be migrated, split it into an exclude_regex for the ObjectName and an exclude_attributes`)
+
+	_, err = migrateCollection([]byte("version: 3\ncollect: []\n"))
+	assert.EqualError(t, err, "unsupported version 3, expected one of 1, 2")
+}
+
+func TestRunMigration(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "test", "data", "test-sample-v1.yml"))
+	assert.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "collection.yml")
+	assert.NoError(t, os.WriteFile(file, data, 0640))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.Equal(t, 0, runMigration(file, stdout, stderr))
+	assert.Contains(t, stdout.String(), "+version: 2\n")
+	assert.Contains(t, stdout.String(), "-          - attr=Mean.*\n")
+	assert.Empty(t, stderr.String())
+
+	migrated, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(migrated), "version: 2")
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	stdout.Reset()
+	assert.Equal(t, 0, runMigration(file, stdout, stderr))
+	assert.Equal(t, file+" is already at version 2\n", stdout.String())
+}
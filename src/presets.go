@@ -0,0 +1,61 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// presetFiles are the built-in collection definitions selected with COLLECTION_PRESETS
+//
+//go:embed presets/*.yml
+var presetFiles embed.FS
+
+// presetNames returns the sorted names of the built-in presets
+func presetNames() []string {
+	files, _ := fs.Glob(presetFiles, "presets/*.yml")
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(path.Base(file), ".yml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// presetSource returns the collection definition of a built-in preset
+func presetSource(name string) ([]byte, error) {
+	data, err := presetFiles.ReadFile("presets/" + name + ".yml")
+	if err != nil {
+		return nil, fmt.Errorf("unknown collection preset %q, expected one of %s", name, strings.Join(presetNames(), ", "))
+	}
+	return data, nil
+}
+
+// loadPreset parses a built-in preset into a collectionDefinitionParser.
+// Its problems are reported as coming from preset:<name>.
+func loadPreset(name string) (*collectionDefinitionParser, error) {
+	data, err := presetSource(name)
+	if err != nil {
+		return nil, err
+	}
+	return parseCollectionBytes(data, "preset:"+name)
+}
+
+// splitPresets returns the names listed in the comma separated COLLECTION_PRESETS
+func splitPresets(presets string) []string {
+	var names []string
+	for _, name := range strings.Split(presets, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
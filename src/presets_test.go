@@ -0,0 +1,39 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresets(t *testing.T) {
+	names := presetNames()
+	assert.Equal(t, []string{
+		"activemq", "cassandra", "hikaricp", "jetty", "jvm", "kafka-broker",
+		"kafka-consumer", "kafka-producer", "solr", "tomcat", "wildfly", "zookeeper",
+	}, names)
+
+	field, _ := reflect.TypeOf(argumentList{}).FieldByName("CollectionPresets")
+	assert.Contains(t, field.Tag.Get("help"), strings.Join(names, ", "))
+
+	for _, name := range names {
+		c, err := loadPreset(name)
+		assert.NoError(t, err, name)
+
+		_, err = parseCollectionDefinition(c)
+		assert.NoError(t, err, name)
+		assert.Empty(t, lintCollectionDefinition(c), name)
+	}
+}
+
+func TestLoadPreset_Unknown(t *testing.T) {
+	_, err := loadPreset("websphere")
+	assert.EqualError(t, err, `unknown collection preset "websphere", expected one of activemq, cassandra, hikaricp, jetty, jvm, kafka-broker, kafka-consumer, kafka-producer, solr, tomcat, wildfly, zookeeper`)
+}
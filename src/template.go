@@ -0,0 +1,191 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// nameTemplate is a name containing {placeholders}, like gc.{name}.collectionCount,
+// whose values are only known once the bean has been queried
+type nameTemplate struct {
+	raw   string
+	parts []templatePart
+}
+
+// templatePart is either a literal piece of a template or a placeholder reference,
+// like {domain|title}, whose value goes through its filters
+type templatePart struct {
+	literal string
+	ref     string
+	filters []string
+}
+
+// templateFilters are the filters that can be applied to a placeholder value
+var templateFilters = map[string]func(string) string{
+	"title": titleCase,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// titleCase capitalizes each word of s and joins them, so kafka.server becomes KafkaServer
+func titleCase(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		first, size := utf8.DecodeRuneInString(word)
+		words[i] = string(unicode.ToUpper(first)) + word[size:]
+	}
+	return strings.Join(words, "")
+}
+
+// isNameTemplate tells whether the name contains placeholders
+func isNameTemplate(name string) bool {
+	return strings.ContainsAny(name, "{}")
+}
+
+// parseNameTemplate splits a template into its literal parts and placeholders
+func parseNameTemplate(raw string) (*nameTemplate, error) {
+	t := &nameTemplate{raw: raw}
+	rest := raw
+	for rest != "" {
+		open := strings.IndexAny(rest, "{}")
+		if open == -1 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if rest[open] == '}' {
+			return nil, fmt.Errorf("invalid template %q: unexpected '}'", raw)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+		}
+
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end == -1 || rest[open+1+end] == '{' {
+			return nil, fmt.Errorf("invalid template %q: unclosed '{'", raw)
+		}
+		pieces := strings.Split(rest[open+1:open+1+end], "|")
+		ref := strings.TrimSpace(pieces[0])
+		if ref == "" {
+			return nil, fmt.Errorf("invalid template %q: empty placeholder", raw)
+		}
+		part := templatePart{ref: ref}
+		for _, filter := range pieces[1:] {
+			filter = strings.TrimSpace(filter)
+			if templateFilters[filter] == nil {
+				return nil, fmt.Errorf("invalid template %q: unknown filter %q, expected one of title, lower, upper", raw, filter)
+			}
+			part.filters = append(part.filters, filter)
+		}
+		t.parts = append(t.parts, part)
+		rest = rest[open+1+end+1:]
+	}
+	return t, nil
+}
+
+// resolve builds the name, replacing each placeholder with the value lookup returns for it
+func (t *nameTemplate) resolve(lookup func(ref string) (string, bool)) (string, error) {
+	sb := strings.Builder{}
+	for _, part := range t.parts {
+		if part.ref == "" {
+			sb.WriteString(part.literal)
+			continue
+		}
+		value, ok := lookup(part.ref)
+		if !ok {
+			return "", fmt.Errorf("can't resolve {%s} in %q", part.ref, t.raw)
+		}
+		for _, filter := range part.filters {
+			value = templateFilters[filter](value)
+		}
+		sb.WriteString(value)
+	}
+	return sb.String(), nil
+}
+
+// beanLookup resolves the placeholders of a metric name template for a bean attribute:
+// {attr} is the attribute name, and {name} or {key:name} the value of the name key property
+func beanLookup(beanAttr string) (func(ref string) (string, bool), error) {
+	attrName, err := getAttrName(beanAttr)
+	if err != nil {
+		return nil, err
+	}
+	beanName, err := getBeanName(beanAttr)
+	if err != nil {
+		return nil, err
+	}
+	keyProperties, err := getKeyProperties(beanName)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ref string) (string, bool) {
+		if ref == "attr" {
+			return attrName, true
+		}
+		value, ok := keyProperties[strings.TrimPrefix(ref, "key:")]
+		return value, ok
+	}, nil
+}
+
+// parseBeanTemplate parses the template of an option resolved for each bean,
+// like event_type, which can't use {attr}
+func parseBeanTemplate(raw, option string) (*nameTemplate, error) {
+	t, err := parseNameTemplate(raw)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range t.parts {
+		if part.ref == "attr" {
+			return nil, fmt.Errorf("invalid template %q: {attr} can't be used in %s, it is resolved for each bean", raw, option)
+		}
+	}
+	return t, nil
+}
+
+// eventTypeLookup resolves the placeholders of an event_type template for a bean:
+// {domain} is the domain of the bean, and {name} or {key:name} the value of the name key property
+func eventTypeLookup(domain, beanName string) (func(ref string) (string, bool), error) {
+	keyProperties, err := getKeyProperties(beanName)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ref string) (string, bool) {
+		if ref == "domain" {
+			return domain, true
+		}
+		value, ok := keyProperties[strings.TrimPrefix(ref, "key:")]
+		return value, ok
+	}, nil
+}
+
+// entityNameLookup resolves the placeholders of an entity name template for a bean.
+// On top of the ones of eventTypeLookup, {host}, {port} and {connection_url} are
+// the ones of the JMX connection.
+func entityNameLookup(domain, beanName, host, port string) (func(ref string) (string, bool), error) {
+	beanLookup, err := eventTypeLookup(domain, beanName)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ref string) (string, bool) {
+		switch ref {
+		case "host":
+			return host, true
+		case "port":
+			return port, true
+		case "connection_url":
+			return args.ConnectionURL, true
+		}
+		return beanLookup(ref)
+	}, nil
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNameTemplate(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    []templatePart
+		expectedErr bool
+	}{
+		{"gc.{name}.collectionCount", []templatePart{{literal: "gc."}, {ref: "name"}, {literal: ".collectionCount"}}, false},
+		{"{attr}", []templatePart{{ref: "attr"}}, false},
+		{"{ key:type }{attr}", []templatePart{{ref: "key:type"}, {ref: "attr"}}, false},
+		{"{domain|title}{ type | lower |upper }Sample", []templatePart{{ref: "domain", filters: []string{"title"}}, {ref: "type", filters: []string{"lower", "upper"}}, {literal: "Sample"}}, false},
+		{"{domain|capitalize}", nil, true},
+		{"gc.{name", nil, true},
+		{"gc.{na{me}", nil, true},
+		{"gc.name}", nil, true},
+		{"gc.{}", nil, true},
+	}
+
+	for _, tc := range testCases {
+		tmpl, err := parseNameTemplate(tc.input)
+		if tc.expectedErr {
+			assert.Error(t, err, tc.input)
+			continue
+		}
+		assert.NoError(t, err, tc.input)
+		assert.Equal(t, tc.expected, tmpl.parts, tc.input)
+	}
+}
+
+func TestNameTemplateResolve(t *testing.T) {
+	lookup, err := beanLookup(`type=GarbageCollector,name=G1 Young Generation,attr=CollectionCount`)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		template    string
+		expected    string
+		expectedErr bool
+	}{
+		{"gc.{name}.{attr}", "gc.G1 Young Generation.CollectionCount", false},
+		{"{key:type}.{attr}", "GarbageCollector.CollectionCount", false},
+		{"gc.{missing}", "", true},
+	}
+
+	for _, tc := range testCases {
+		tmpl, err := parseNameTemplate(tc.template)
+		assert.NoError(t, err)
+
+		name, err := tmpl.resolve(lookup)
+		assert.Equal(t, tc.expectedErr, err != nil, tc.template)
+		assert.Equal(t, tc.expected, name)
+	}
+}
+
+func TestTitleCase(t *testing.T) {
+	assert.Equal(t, "KafkaServer", titleCase("kafka.server"))
+	assert.Equal(t, "G1YoungGeneration", titleCase("G1 Young Generation"))
+	assert.Equal(t, "RequestChannel", titleCase("request-channel"))
+	assert.Equal(t, "", titleCase(".."))
+	assert.Equal(t, "ÉtatÜbersicht", titleCase("état.übersicht"))
+}
+
+func TestParseBeanTemplate(t *testing.T) {
+	_, err := parseBeanTemplate("{domain|title}{key:type}Sample", "event_type")
+	assert.NoError(t, err)
+
+	_, err = parseBeanTemplate("{attr}Sample", "event_type")
+	assert.EqualError(t, err, `invalid template "{attr}Sample": {attr} can't be used in event_type, it is resolved for each bean`)
+}
+
+func TestEventTypeLookup(t *testing.T) {
+	lookup, err := eventTypeLookup("kafka.server", "type=BrokerTopicMetrics,domain=other")
+	assert.NoError(t, err)
+
+	value, ok := lookup("domain")
+	assert.True(t, ok)
+	assert.Equal(t, "kafka.server", value)
+	value, _ = lookup("key:domain")
+	assert.Equal(t, "other", value)
+	value, _ = lookup("type")
+	assert.Equal(t, "BrokerTopicMetrics", value)
+	_, ok = lookup("name")
+	assert.False(t, ok)
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// unitDefinition is a unit that values can be converted from and to.
+// Units can only be converted to units of the same dimension.
+type unitDefinition struct {
+	dimension string
+	// size is the unit expressed in the smallest unit of its dimension, so
+	// that conversions only multiply and divide by exact integers
+	size float64
+}
+
+var units = map[string]unitDefinition{
+	"ns":           {"time", 1},
+	"nanoseconds":  {"time", 1},
+	"us":           {"time", 1e3},
+	"microseconds": {"time", 1e3},
+	"ms":           {"time", 1e6},
+	"milliseconds": {"time", 1e6},
+	"s":            {"time", 1e9},
+	"seconds":      {"time", 1e9},
+	"min":          {"time", 60e9},
+	"minutes":      {"time", 60e9},
+	"h":            {"time", 3600e9},
+	"hours":        {"time", 3600e9},
+	"d":            {"time", 86400e9},
+	"days":         {"time", 86400e9},
+
+	"bytes":     {"size", 1},
+	"kilobytes": {"size", 1e3},
+	"megabytes": {"size", 1e6},
+	"gigabytes": {"size", 1e9},
+	"terabytes": {"size", 1e12},
+	"kb":        {"size", 1e3},
+	"mb":        {"size", 1e6},
+	"gb":        {"size", 1e9},
+	"tb":        {"size", 1e12},
+	"kib":       {"size", 1 << 10},
+	"mib":       {"size", 1 << 20},
+	"gib":       {"size", 1 << 30},
+	"tib":       {"size", 1 << 40},
+
+	"percent": {"fraction", 1},
+	"ratio":   {"fraction", 100},
+}
+
+// valueTransform converts the value of an attribute before it is reported.
+// The unit conversion is applied first, then the scale and the offset.
+type valueTransform struct {
+	scale  float64
+	offset float64
+	// from and to are the sizes of the units converted between,
+	// both 1 when there's no conversion
+	from float64
+	to   float64
+	// unit is the unit the reported value is in
+	unit string
+}
+
+// lookupUnit finds a unit by its name, ignoring case
+func lookupUnit(name string) (unitDefinition, bool) {
+	u, ok := units[strings.ToLower(name)]
+	return u, ok
+}
+
+// parseValueTransform reads the scale, offset, unit and to_unit options of
+// an attribute definition. It returns nil when none of them is set.
+func parseValueTransform(a map[string]interface{}) (*valueTransform, error) {
+	_, hasScale := a["scale"]
+	_, hasOffset := a["offset"]
+	unitName, hasUnit := a["unit"]
+	toUnitName, hasToUnit := a["to_unit"]
+	if !hasScale && !hasOffset && !hasUnit && !hasToUnit {
+		return nil, nil
+	}
+
+	t := &valueTransform{scale: 1, from: 1, to: 1}
+
+	if hasScale {
+		scale, ok := toFloat64(a["scale"])
+		if !ok {
+			return nil, fmt.Errorf("invalid scale %v, must be a number", a["scale"])
+		}
+		t.scale = scale
+	}
+	if hasOffset {
+		offset, ok := toFloat64(a["offset"])
+		if !ok {
+			return nil, fmt.Errorf("invalid offset %v, must be a number", a["offset"])
+		}
+		t.offset = offset
+	}
+
+	if hasToUnit && !hasUnit {
+		return nil, fmt.Errorf("to_unit requires the unit of the attribute to be set with unit")
+	}
+	if hasUnit {
+		from, ok := unitName.(string)
+		fromUnit, known := lookupUnit(from)
+		if !ok || (hasToUnit && !known) {
+			return nil, fmt.Errorf("unknown unit %v", unitName)
+		}
+		t.unit = from
+
+		if hasToUnit {
+			to, ok := toUnitName.(string)
+			toUnit, known := lookupUnit(to)
+			if !ok || !known {
+				return nil, fmt.Errorf("unknown unit %v", toUnitName)
+			}
+			if fromUnit.dimension != toUnit.dimension {
+				return nil, fmt.Errorf("can't convert %s to %s", from, to)
+			}
+			t.from, t.to = fromUnit.size, toUnit.size
+			t.unit = to
+		}
+	}
+	return t, nil
+}
+
+// converts tells whether applying the transform changes the value
+func (t *valueTransform) converts() bool {
+	return t.from != t.to || t.scale != 1 || t.offset != 0
+}
+
+// apply converts a numeric value. Strings holding a number are converted as well.
+func (t *valueTransform) apply(val interface{}) (float64, error) {
+	v, ok := toFloat64(val)
+	if !ok {
+		s, isString := val.(string)
+		if !isString {
+			return 0, fmt.Errorf("can't convert non numeric value %v", val)
+		}
+		var err error
+		if v, err = strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+			return 0, fmt.Errorf("can't convert non numeric value %q", s)
+		}
+	}
+	return v*t.from/t.to*t.scale + t.offset, nil
+}
+
+// toFloat64 converts any numeric type to float64
+func toFloat64(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueTransform(t *testing.T) {
+	testCases := []struct {
+		options      map[string]interface{}
+		input        interface{}
+		expected     float64
+		expectedUnit string
+	}{
+		{map[string]interface{}{"unit": "ns", "to_unit": "ms"}, int64(1500000), 1.5, "ms"},
+		{map[string]interface{}{"unit": "bytes", "to_unit": "MiB"}, int64(3 << 20), 3, "MiB"},
+		{map[string]interface{}{"unit": "kilobytes", "to_unit": "bytes"}, 2.5, 2500, "bytes"},
+		{map[string]interface{}{"unit": "ratio", "to_unit": "percent"}, 0.25, 25, "percent"},
+		{map[string]interface{}{"scale": 100}, 0.5, 50, ""},
+		{map[string]interface{}{"scale": 0.001, "offset": -1, "unit": "requests"}, "2000", 1, "requests"},
+		{map[string]interface{}{"unit": "s", "to_unit": "ms", "scale": 2, "offset": 1.0}, 3, 6001, "ms"},
+	}
+
+	for _, tc := range testCases {
+		transform, err := parseValueTransform(tc.options)
+		assert.NoError(t, err)
+
+		out, err := transform.apply(tc.input)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, out, tc.options)
+		assert.Equal(t, tc.expectedUnit, transform.unit)
+	}
+}
+
+func TestParseValueTransform_Errors(t *testing.T) {
+	testCases := []map[string]interface{}{
+		{"to_unit": "ms"},
+		{"unit": "ns", "to_unit": "MiB"},
+		{"unit": "furlongs", "to_unit": "ms"},
+		{"unit": "ns", "to_unit": "fortnights"},
+		{"scale": "ten"},
+		{"offset": true},
+	}
+
+	for _, options := range testCases {
+		_, err := parseValueTransform(options)
+		assert.Error(t, err, options)
+	}
+
+	transform, err := parseValueTransform(map[string]interface{}{"attr": "Test"})
+	assert.NoError(t, err)
+	assert.Nil(t, transform)
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+// Type inference modes of TYPE_INFERENCE and type_inference. The default mode
+// reports every number as a gauge, the heuristic mode reports the numbers
+// matching a typeInferenceRule with its metric type.
+const (
+	typeInferenceDefault   = "default"
+	typeInferenceHeuristic = "heuristic"
+)
+
+var typeInferenceModes = []string{typeInferenceDefault, typeInferenceHeuristic}
+
+// defaultTypeInferenceRules are the heuristic rules used unless TYPE_INFERENCE_RULES
+// is set. They match the cumulative counters of the JVM and of the common servers.
+const defaultTypeInferenceRules = "Count:rate," +
+	"*Count@type=GarbageCollector:rate," +
+	"*Time@type=GarbageCollector:delta," +
+	"Total*Count:rate," +
+	"Total*Time:delta," +
+	"max*@type=GlobalRequestProcessor:gauge," +
+	"*Count@type=GlobalRequestProcessor:rate," +
+	"*Time@type=GlobalRequestProcessor:delta," +
+	"bytes*@type=GlobalRequestProcessor:rate," +
+	"*Total:rate"
+
+// typeInferenceRule gives a metric type to the numeric attributes matching it
+type typeInferenceRule struct {
+	// raw is the rule as written, to report which rule inferred a type
+	raw string
+	// attr is a glob matched against the lowercased attribute name
+	attr string
+	// bean, when set, is a glob one of the key properties of the bean must match, like type=Garbage*
+	bean       string
+	metricType metric.SourceType
+}
+
+// typeInferenceRules are the rules of the heuristic mode, parsed from TYPE_INFERENCE_RULES
+var typeInferenceRules []*typeInferenceRule
+
+// inferredTypes are the attributes and rules already reported in the audit log
+var inferredTypes = make(map[string]bool)
+
+// checkTypeInference checks the type inference mode is one of typeInferenceModes
+func checkTypeInference(mode string) error {
+	for _, m := range typeInferenceModes {
+		if mode == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown mode %q, expected one of %s", mode, strings.Join(typeInferenceModes, ", "))
+}
+
+// parseTypeInferenceRules parses a comma separated list of attrGlob[@beanGlob]:type rules,
+// like *Count@type=GarbageCollector:rate. The first rule matching an attribute is used.
+func parseTypeInferenceRules(raw string) ([]*typeInferenceRule, error) {
+	var rules []*typeInferenceRule
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		i := strings.LastIndex(item, ":")
+		if i == -1 {
+			return nil, fmt.Errorf("invalid rule %q, expected attrGlob[@beanGlob]:type", item)
+		}
+		metricType, ok := metric.SourcesNameToType[strings.ToLower(item[i+1:])]
+		if !ok {
+			return nil, fmt.Errorf("invalid rule %q, unknown metric type %q, expected one of %s", item, item[i+1:], strings.Join(metricTypeNames(), ", "))
+		}
+
+		rule := &typeInferenceRule{raw: item, attr: item[:i], metricType: metricType}
+		if j := strings.Index(rule.attr, "@"); j != -1 {
+			rule.attr, rule.bean = rule.attr[:j], rule.attr[j+1:]
+			if _, err := path.Match(rule.bean, ""); err != nil {
+				return nil, fmt.Errorf("invalid rule %q: %v", item, err)
+			}
+		}
+		rule.attr = strings.ToLower(rule.attr)
+		if _, err := path.Match(rule.attr, ""); rule.attr == "" || err != nil {
+			return nil, fmt.Errorf("invalid rule %q, expected an attribute glob", item)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// matches tells whether the rule applies to the attribute of the bean
+func (r *typeInferenceRule) matches(beanName, attrName string) bool {
+	if ok, _ := path.Match(r.attr, strings.ToLower(attrName)); !ok {
+		return false
+	}
+	if r.bean == "" {
+		return true
+	}
+	for _, property := range strings.Split(beanName, ",") {
+		if ok, _ := path.Match(r.bean, property); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// inferAttributeType infers the metric type of the value of an attribute. In the heuristic
+// mode, set by the type_inference of the collect block or else TYPE_INFERENCE, numbers
+// matching a rule get its metric type, which is logged the first time.
+func inferAttributeType(key string, val interface{}, mode string) metric.SourceType {
+	metricType := inferMetricType(val)
+	if mode == "" {
+		mode = args.TypeInference
+	}
+	if metricType != metric.GAUGE || mode != typeInferenceHeuristic {
+		return metricType
+	}
+
+	beanName, err := getBeanName(key)
+	if err != nil {
+		return metricType
+	}
+	attrName, err := getAttrName(key)
+	if err != nil {
+		return metricType
+	}
+	for _, rule := range typeInferenceRules {
+		if !rule.matches(beanName, attrName) {
+			continue
+		}
+		audit := attrName + "\x00" + rule.raw
+		if !inferredTypes[audit] {
+			inferredTypes[audit] = true
+			log.Info("Inferred metric type %s for attribute %s of %s from rule %s", rule.metricType, attrName, beanName, rule.raw)
+		}
+		return rule.metricType
+	}
+	return metricType
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTypeInferenceRules(t *testing.T) {
+	rules, err := parseTypeInferenceRules("*Count@type=GarbageCollector:rate, Total*Time:DELTA")
+	assert.NoError(t, err)
+	assert.Equal(t, []*typeInferenceRule{
+		{raw: "*Count@type=GarbageCollector:rate", attr: "*count", bean: "type=GarbageCollector", metricType: metric.RATE},
+		{raw: "Total*Time:DELTA", attr: "total*time", metricType: metric.DELTA},
+	}, rules)
+
+	rules, err = parseTypeInferenceRules(defaultTypeInferenceRules)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 10)
+
+	for _, raw := range []string{"*Count", "*Count:counter", ":rate", "[Count:rate", "*Count@[type:rate"} {
+		_, err := parseTypeInferenceRules(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestInferAttributeType(t *testing.T) {
+	defer func() { args = argumentList{}; typeInferenceRules = nil }()
+	args = argumentList{JmxHost: "localhost", TypeInference: typeInferenceHeuristic}
+	var err error
+	typeInferenceRules, err = parseTypeInferenceRules(defaultTypeInferenceRules)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		key      string
+		val      interface{}
+		mode     string
+		expected metric.SourceType
+	}{
+		{"type=GarbageCollector,name=G1,attr=CollectionCount", 10, "", metric.RATE},
+		{"type=GarbageCollector,name=G1,attr=CollectionTime", 10, "", metric.DELTA},
+		{"type=Threading,attr=TotalStartedThreadCount", 10, "", metric.RATE},
+		{"type=Threading,attr=ThreadCount", 10, "", metric.GAUGE},
+		{"type=GlobalRequestProcessor,name=http,attr=requestCount", 10, "", metric.RATE},
+		{"type=GlobalRequestProcessor,name=http,attr=maxTime", 10, "", metric.GAUGE},
+		{"type=GlobalRequestProcessor,name=http,attr=bytesSent", 10, "", metric.RATE},
+		{"type=Stats,attr=MessagesTotal", 10, "", metric.RATE},
+		{"type=Stats,attr=MessagesTotal", "n/a", "", metric.ATTRIBUTE},
+		{"type=GarbageCollector,name=G1,attr=CollectionCount", 10, typeInferenceDefault, metric.GAUGE},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, inferAttributeType(tc.key, tc.val, tc.mode), tc.key)
+	}
+
+	args.TypeInference = typeInferenceDefault
+	assert.Equal(t, metric.GAUGE, inferAttributeType("type=GarbageCollector,name=G1,attr=CollectionCount", 10, ""))
+	assert.Equal(t, metric.RATE, inferAttributeType("type=GarbageCollector,name=G1,attr=CollectionCount", 10, typeInferenceHeuristic))
+}
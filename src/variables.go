@@ -0,0 +1,162 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// secretVariables are the credentials of the integration. They can't be used in collection
+// definitions, where they would end up in metric names, attributes and logs.
+var secretVariables = map[string]bool{"JMX_PASS": true, "KEY_STORE_PASSWORD": true, "TRUST_STORE_PASSWORD": true}
+
+// lookupVariable resolves a variable of a collection definition from the environment
+// first, then from the integration arguments, named like their environment variable
+func lookupVariable(name string) (string, bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	if f := flag.Lookup(strings.ToLower(name)); f != nil {
+		return f.Value.String(), true
+	}
+	return "", false
+}
+
+// expandVariables replaces the ${VAR} and ${VAR:-default} references of a collection
+// definition with their values, resolved with lookup. The default is used when the
+// variable is unset or empty. $${ is kept as a literal ${.
+func expandVariables(data string, lookup func(name string) (string, bool)) (string, error) {
+	sb := strings.Builder{}
+	rest := data
+	for {
+		start := strings.Index(rest, "${")
+		if start == -1 {
+			sb.WriteString(rest)
+			return sb.String(), nil
+		}
+
+		// $${ escapes the reference
+		if start > 0 && rest[start-1] == '$' {
+			sb.WriteString(rest[:start-1])
+			sb.WriteString("${")
+			rest = rest[start+2:]
+			continue
+		}
+		sb.WriteString(rest[:start])
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("unclosed variable reference %q", firstLine(rest[start:]))
+		}
+		reference := rest[start+2 : start+end]
+		rest = rest[start+end+1:]
+
+		name, defaultValue, hasDefault := strings.Cut(reference, ":-")
+		if !isVariableName(name) {
+			return "", fmt.Errorf("invalid variable reference ${%s}", reference)
+		}
+		if secretVariables[name] {
+			return "", fmt.Errorf("variable %s is a credential, it can't be used in collection definitions", name)
+		}
+
+		value, ok := lookup(name)
+		switch {
+		case hasDefault && value == "":
+			value = defaultValue
+		case !ok:
+			return "", fmt.Errorf("undefined variable %s, set it or give it a default with ${%s:-default}", name, name)
+		}
+		sb.WriteString(value)
+	}
+}
+
+// expandNodeVariables expands the variable references of the scalar values of a decoded
+// collection definition. Comments and keys are left as they are, values can't change
+// the structure of the document and the nodes keep their position in the file.
+func expandNodeVariables(node *yaml.Node, source string, lookup func(name string) (string, bool)) collectionErrors {
+	var errs collectionErrors
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		switch n.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, child := range n.Content {
+				walk(child)
+			}
+		case yaml.MappingNode:
+			for i := 1; i < len(n.Content); i += 2 {
+				walk(n.Content[i])
+			}
+		case yaml.ScalarNode:
+			if !strings.Contains(n.Value, "${") {
+				return
+			}
+			expanded, err := expandVariables(n.Value, lookup)
+			if err != nil {
+				pos := nodePosition(n)
+				pos.file = source
+				errs.add(pos, err)
+				return
+			}
+			n.Value = expanded
+			// Plain values get the type of their expanded value, as if it was written in the file
+			if n.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+				n.Tag = ""
+				n.Tag = n.ShortTag()
+			}
+		}
+	}
+	walk(node)
+	return errs
+}
+
+// expandJSONVariables expands the variable references of the string values of a decoded
+// JSON document, for the collection configs the yaml decoder can't read
+func expandJSONVariables(value interface{}, lookup func(name string) (string, bool)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return expandVariables(v, lookup)
+	case []interface{}:
+		for i, item := range v {
+			expanded, err := expandJSONVariables(item, lookup)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			expanded, err := expandJSONVariables(item, lookup)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = expanded
+		}
+	}
+	return value, nil
+}
+
+func isVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		isLetter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !isLetter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
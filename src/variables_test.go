@@ -0,0 +1,116 @@
+/*
+ * Copyright 2022 New Relic Corporation. All rights reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandVariables(t *testing.T) {
+	variables := map[string]string{
+		"CLIENT_ID":  "orders",
+		"EMPTY":      "",
+		"DATASOURCE": "jdbc/main",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := variables[name]
+		return value, ok
+	}
+
+	testCases := []struct {
+		data     string
+		expected string
+	}{
+		{"query: type=app-info,client-id=${CLIENT_ID}", "query: type=app-info,client-id=orders"},
+		{"${DATASOURCE}-${CLIENT_ID}", "jdbc/main-orders"},
+		{"${UNSET:-default}", "default"},
+		{"${EMPTY:-default}", "default"},
+		{"${CLIENT_ID:-default}", "orders"},
+		{"[${EMPTY}]", "[]"},
+		{"$${CLIENT_ID}", "${CLIENT_ID}"},
+		{"attr=Count$", "attr=Count$"},
+	}
+
+	for _, tc := range testCases {
+		actual, err := expandVariables(tc.data, lookup)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, actual)
+	}
+
+	_, err := expandVariables("client-id=${UNSET}", lookup)
+	assert.EqualError(t, err, "undefined variable UNSET, set it or give it a default with ${UNSET:-default}")
+	_, err = expandVariables("client-id=${CLIENT_ID\nquery: x", lookup)
+	assert.EqualError(t, err, `unclosed variable reference "${CLIENT_ID"`)
+	_, err = expandVariables("${1ID}", lookup)
+	assert.EqualError(t, err, "invalid variable reference ${1ID}")
+
+	// Credentials are never expanded, even with a default
+	for _, data := range []string{"${JMX_PASS}", "${KEY_STORE_PASSWORD:-x}", "${TRUST_STORE_PASSWORD}"} {
+		_, err = expandVariables(data, func(string) (string, bool) { return "secret", true })
+		assert.ErrorContains(t, err, "is a credential, it can't be used in collection definitions", data)
+	}
+}
+
+func TestParseJSON_SecretVariables(t *testing.T) {
+	t.Setenv("JMX_PASS", "s3cr3t")
+
+	_, err := parseJSON(`{"collect": [{"domain": "a", "event_type": "A", "beans": [{"query": "pass=${JMX_PASS}"}]}]}`)
+	assert.ErrorContains(t, err, "variable JMX_PASS is a credential")
+	assert.NotContains(t, err.Error(), "s3cr3t")
+}
+
+func TestParseJSON_Variables(t *testing.T) {
+	t.Setenv("NRI_JMX_TEST_CLIENT", "orders")
+
+	c, err := parseJSON(`{"collect": [{"domain": "kafka.producer", "event_type": "KafkaSample",
+		"beans": [{"query": "type=producer-metrics,client-id=${NRI_JMX_TEST_CLIENT}", "attributes": ["${NRI_JMX_TEST_ATTR:-record-send-rate}"]}]}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "type=producer-metrics,client-id=orders", c.Collect[0].Beans[0].Query)
+	assert.Equal(t, []interface{}{"record-send-rate"}, c.Collect[0].Beans[0].Attributes)
+}
+
+func TestParseJSON_VariablesAreValues(t *testing.T) {
+	t.Setenv("NRI_JMX_TEST_CLIENT", `a","x":"y`)
+
+	c, err := parseJSON(`{"collect": [{"domain": "kafka.producer", "event_type": "KafkaSample",
+		"beans": [{"query": "client-id=${NRI_JMX_TEST_CLIENT}"}]}]}`)
+	assert.NoError(t, err)
+	assert.Empty(t, c.problems)
+	assert.Equal(t, `client-id=a","x":"y`, c.Collect[0].Beans[0].Query)
+}
+
+func TestParseYaml_Variables(t *testing.T) {
+	t.Setenv("NRI_JMX_TEST_QUERY", "type=producer-metrics,\nclient-id=orders")
+	file := filepath.Join(t.TempDir(), "kafka.yml")
+	assert.NoError(t, os.WriteFile(file, []byte(`collect:
+  # - domain: kafka.consumer
+  #   beans:
+  #     - query: client-id=${NRI_JMX_TEST_UNSET}
+  - domain: kafka.producer
+    event_type: KafkaSample
+    beans:
+      - query: "${NRI_JMX_TEST_QUERY}"
+        attributes:
+          - attr: record-send-rate
+            scale: ${NRI_JMX_TEST_SCALE:-2}
+          - atr: record-error-rate
+`), 0o600))
+
+	// Comments are not expanded, and positions are the ones of the file
+	c, err := parseYaml(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "type=producer-metrics,\nclient-id=orders", c.Collect[0].Beans[0].Query)
+	_, err = parseCollectionDefinition(c)
+	assert.ErrorContains(t, err, file+`:12:13: unknown key "atr" in attribute`)
+
+	assert.NoError(t, os.WriteFile(file, []byte("collect:\n  - domain: kafka.producer\n    beans:\n      - query: client-id=${NRI_JMX_TEST_UNSET}\n"), 0o600))
+	_, err = parseYaml(file)
+	assert.EqualError(t, err, file+":4:16: undefined variable NRI_JMX_TEST_UNSET, set it or give it a default with ${NRI_JMX_TEST_UNSET:-default}")
+}
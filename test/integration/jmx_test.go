@@ -87,6 +87,9 @@ func TestJMXIntegration(t *testing.T) {
 	schemaPath := filepath.Join("json-schema-files", "jmx-schema.json")
 	err = jsonschema.Validate(schemaPath, stdout)
 	assert.NoError(t, err, "The output of JMX integration doesn't have expected format.")
+
+	// Healthy runs report no failed collection files
+	assert.Contains(t, stdout, `"collectionFiles.failed":0`)
 }
 
 func TestJMXIntegrationJSONConfig(t *testing.T) {
@@ -159,7 +162,7 @@ func TestJMXIntegration_ErrorOpenFuncOnInvalidOptions(t *testing.T) {
 func TestJMXIntegration_ErrorEmptyCollectionFiles(t *testing.T) {
 	stdout, stderr, err := runIntegration(t, "COLLECTION_FILES=")
 
-	expectedErrorMessage := "Must specify at least one collection file or a collection config JSON"
+	expectedErrorMessage := "Must specify at least one collection file, a collection config JSON or a collection preset"
 
 	errMatch, _ := regexp.MatchString(expectedErrorMessage, stderr)
 	assert.Error(t, err, "Expected error")
@@ -168,22 +171,66 @@ func TestJMXIntegration_ErrorEmptyCollectionFiles(t *testing.T) {
 	assert.Empty(t, stdout, "unexpected stdout")
 }
 
-func TestJMXIntegration_ErrorCollectionFileNotAbsolutePath(t *testing.T) {
-	stdout, stderr, err := runIntegration(t, "COLLECTION_FILES=wrong_file.yml")
+func TestJMXIntegration_CollectionFilesResolution(t *testing.T) {
+	testCases := []struct {
+		name    string
+		envVars []string
+	}{
+		// Relative paths are resolved against the directory of the config file
+		{"relative paths", []string{"CONFIG_FILE=/jmx-conf/jmx-config.yml", "COLLECTION_FILES=jvm-metrics.yml,tomcat-metrics.yml"}},
+		{"directory", []string{"COLLECTION_FILES=/jmx-conf"}},
+		{"glob", []string{"COLLECTION_FILES=/jmx-conf/*-metrics.yml"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stdout, stderr, err := runIntegration(t, tc.envVars...)
 
-	expectedErrorMessage := "Metrics collection files must be specified as absolute paths"
+			assert.Empty(t, stderr, "unexpected stderr")
+			assert.NoError(t, err, "Unexpected error")
+
+			schemaPath := filepath.Join("json-schema-files", "jmx-schema.json")
+			err = jsonschema.Validate(schemaPath, stdout)
+			assert.NoError(t, err, "The output of JMX integration doesn't have expected format.")
+		})
+	}
+}
+
+func TestJMXIntegration_CollectionFileNotExisting(t *testing.T) {
+	stdout, stderr, err := runIntegration(t, "COLLECTION_FILES=/wrong_file.yml")
+
+	expectedErrorMessage := "Skipping collection definition file /wrong_file.yml, failed to parse it"
 
 	errMatch, _ := regexp.MatchString(expectedErrorMessage, stderr)
-	assert.Error(t, err, "Expected error")
+	assert.NoError(t, err, "Unexpected error")
 	assert.Truef(t, errMatch, "Expected error message: '%s', got: '%s'", expectedErrorMessage, stderr)
 
-	assert.Empty(t, stdout, "unexpected stdout")
+	// The failed file is reported in a JMXCollectionFilesSample
+	schemaPath := filepath.Join("json-schema-files", "jmx-schema-collection-files-failed.json")
+	err = jsonschema.Validate(schemaPath, stdout)
+	assert.NoError(t, err, "The output of JMX integration doesn't have expected format.")
 }
 
-func TestJMXIntegration_ErrorCollectionFileNotExisting(t *testing.T) {
-	stdout, stderr, err := runIntegration(t, "COLLECTION_FILES=/wrong_file.yml")
+func TestJMXIntegration_CollectionFileNotExistingSkipped(t *testing.T) {
+	stdout, stderr, err := runIntegration(t, "COLLECTION_FILES=/jvm-metrics.yml,/wrong_file.yml,/tomcat-metrics.yml")
+
+	expectedErrorMessage := "Skipping collection definition file /wrong_file.yml, failed to parse it"
+
+	errMatch, _ := regexp.MatchString(expectedErrorMessage, stderr)
+	assert.NoError(t, err, "Unexpected error")
+	assert.Truef(t, errMatch, "Expected error message: '%s', got: '%s'", expectedErrorMessage, stderr)
+
+	// The other files are collected, and the domain entities come first
+	schemaPath := filepath.Join("json-schema-files", "jmx-schema.json")
+	err = jsonschema.Validate(schemaPath, stdout)
+	assert.NoError(t, err, "The output of JMX integration doesn't have expected format.")
+	assert.Contains(t, stdout, `"collectionFiles.failed":1`)
+}
+
+func TestJMXIntegration_ErrorCollectionFileNotExistingStrict(t *testing.T) {
+	stdout, stderr, err := runIntegration(t, "COLLECTION_FILES=/wrong_file.yml", "STRICT_COLLECTION_FILES=true")
 
-	expectedErrorMessage := "Failed to parse collection definition"
+	expectedErrorMessage := "Failed to load 1 of 1 collection files"
 
 	errMatch, _ := regexp.MatchString(expectedErrorMessage, stderr)
 	assert.Error(t, err, "Expected error")